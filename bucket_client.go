@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// bucketClient makes the requests to the bucket of the dumps, falling back
+// to its mirrors when it fails. It's shared by all the commands, configured
+// by the global flags.
+type bucketClient struct {
+	client *http.Client
+	// URLs are the URL of the bucket followed by the URLs of its mirrors,
+	// tried in order.
+	URLs      []string
+	UserAgent string
+	// Timeout is how long to wait for a connection, for the headers of a
+	// response, and for each read of its body.
+	Timeout time.Duration
+	// Retries is the number of times the bucket and its mirrors are tried
+	// again when they all fail with a network error, a 429 or a 5xx.
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// newBucketClient creates the client configured by the global flags.
+func newBucketClient(cmd *cli.Command) (*bucketClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used unless a proxy is given.
+	transport.Proxy = http.ProxyFromEnvironment
+	if cmd.String("http-proxy") != "" {
+		proxy, err := url.Parse(cmd.String("http-proxy"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %s: %w", cmd.String("http-proxy"), err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	timeout := cmd.Duration("http-timeout")
+	if timeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+		transport.TLSHandshakeTimeout = timeout
+		transport.ResponseHeaderTimeout = timeout
+	}
+	if cmd.String("ca-cert") != "" {
+		roots, err := certPoolWith(cmd.String("ca-cert"))
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+
+	urls := []string{strings.TrimSuffix(cmd.String("discogs-bucket"), "/")}
+	for _, mirror := range cmd.StringSlice("bucket-mirror") {
+		if mirror != "" {
+			urls = append(urls, strings.TrimSuffix(mirror, "/"))
+		}
+	}
+
+	return &bucketClient{
+		client:     &http.Client{Transport: transport},
+		URLs:       urls,
+		UserAgent:  cmd.String("user-agent"),
+		Timeout:    timeout,
+		Retries:    cmd.Int("http-retries"),
+		Backoff:    time.Second,
+		MaxBackoff: 30 * time.Second,
+	}, nil
+}
+
+// certPoolWith returns the system certificate pool with the certificates of
+// a PEM bundle added.
+func certPoolWith(bundle string) (*x509.CertPool, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	pem, err := os.ReadFile(bundle)
+	if err != nil {
+		return nil, err
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", bundle)
+	}
+
+	return roots, nil
+}
+
+// Bucket returns the URL of the bucket.
+func (c *bucketClient) Bucket() string {
+	return c.URLs[0]
+}
+
+// url returns the URL of a key of a bucket, or of a query when ref starts
+// with a question mark.
+func (c *bucketClient) url(base, ref string) string {
+	if strings.HasPrefix(ref, "?") {
+		return base + "/" + ref
+	}
+
+	return base + "/" + strings.TrimPrefix(ref, "/")
+}
+
+// Get requests a key of the bucket, or a query when ref starts with a
+// question mark, with the headers given.
+//
+// The bucket and its mirrors are tried in order until one responds with
+// anything but a network error, a 403, a 404, a 429 or a 5xx. When they all
+// fail, they are tried again after a backoff, up to Retries times, unless
+// none failed with a temporary error. The last response is returned then,
+// for the caller to report its status.
+func (c *bucketClient) Get(ctx context.Context, ref string, header http.Header) (*http.Response, error) {
+	resp, _, err := c.getFrom(ctx, c.URLs, ref, header)
+	return resp, err
+}
+
+// getFrom requests ref from the first of bases that responds, as Get does,
+// and returns the base that served the response.
+func (c *bucketClient) getFrom(ctx context.Context, bases []string, ref string, header http.Header) (*http.Response, string, error) {
+	backoff := c.Backoff
+	for retries := 0; ; retries++ {
+		var last *http.Response
+		var lastErr error
+		temporary := false
+		for _, base := range bases {
+			resp, err := c.get(ctx, c.url(base, ref), header)
+			if ctx.Err() != nil {
+				return nil, "", ctx.Err()
+			}
+			if err == nil && !fallback(resp.StatusCode) {
+				if last != nil {
+					last.Body.Close()
+				}
+				return resp, base, nil
+			}
+			if last != nil {
+				last.Body.Close()
+			}
+			last, lastErr = resp, err
+			temporary = temporary || err != nil || retryable(resp.StatusCode)
+		}
+
+		if !temporary || retries >= c.Retries {
+			return last, "", lastErr
+		}
+		if last != nil {
+			last.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, c.MaxBackoff)
+	}
+}
+
+func (c *bucketClient) get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if c.Timeout > 0 {
+		resp.Body = newIdleTimeoutBody(resp.Body, c.Timeout, cancel)
+	} else {
+		resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	}
+
+	return resp, nil
+}
+
+// fallback returns true if the bucket responding with status may be
+// replaced by a mirror.
+func fallback(status int) bool {
+	return status == http.StatusForbidden || status == http.StatusNotFound || retryable(status)
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// cancelBody cancels the context of its request when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// idleTimeoutBody fails the reads of a response body once no data was
+// received for timeout, which a stalled connection would block forever.
+// Only the time spent in Read counts, a reader consuming the body slowly
+// doesn't time out.
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+	cancel  context.CancelFunc
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, timeout: timeout, cancel: cancel}
+	// The timer is armed by Read.
+	b.timer = time.AfterFunc(timeout, func() {
+		b.expired.Store(true)
+		cancel()
+	})
+	b.timer.Stop()
+
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.body.Read(p)
+	b.timer.Stop()
+	if b.expired.Load() {
+		return n, fmt.Errorf("no data received for %s", b.timeout)
+	}
+
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	defer b.cancel()
+
+	return b.body.Close()
+}
@@ -4,15 +4,26 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/marcw/dgtools/internal/discogs/markup"
 	"github.com/urfave/cli/v3"
 )
 
+// maxImportedTables is the number of tables of the largest dumps, releases
+// with their mentions.
+const maxImportedTables = 5
+
 var dbImportCmd = &cli.Command{
 	Name:  "import",
 	Usage: "Import data from a dump file to the database",
@@ -22,22 +33,87 @@ var dbImportCmd = &cli.Command{
 			UsageText: "The file to import the data from",
 		},
 	},
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "normalize",
+			Usage: "Import the values parsed from release dates, track durations and track positions, unparseable values are kept verbatim and reported",
+			Value: false,
+		},
+		&cli.StringFlag{
+			Name:  "markup",
+			Usage: fmt.Sprintf("Render the markup of profiles and notes as %s, kept as is otherwise", strings.Join(markup.Formats, ", ")),
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if !slices.Contains(markup.Formats, s) {
+					return fmt.Errorf("supported markup formats are: %s", strings.Join(markup.Formats, ", "))
+				}
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:  "mentions",
+			Usage: "Import the artists, labels, releases and masters referenced by ID in profiles and notes to a mentions table",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Import the dump even if the catalog tells it was already imported into the database",
+			Value: false,
+		},
+		&cli.StringFlag{
+			Name:  "from-bucket",
+			Usage: "Download the dump with this name or key from the bucket while importing it, and import it only if its checksum matches. The database needs room for a second copy of the tables of the dump while importing",
+		},
+		&cli.StringFlag{
+			Name:  "out-dir",
+			Usage: "The directory the dump imported with --from-bucket is downloaded to",
+			Value: ".",
+		},
+	},
 	Action: func(ctx context.Context, cmd *cli.Command) error {
-		pool, err := pgxpool.New(context.Background(), cmd.String("database-url"))
+		config, err := pgxpool.ParseConfig(cmd.String("database-url"))
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer pool.Close()
-
-		conn, err := pool.Acquire(context.Background())
+		// The tables of a dump are copied at once, each holding a
+		// connection until the whole dump is copied.
+		config.MaxConns = max(config.MaxConns, maxImportedTables)
+		pool, err := pgxpool.NewWithConfig(context.Background(), config)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer conn.Release()
+		defer pool.Close()
 
 		file := cmd.StringArg("file")
-		if file == "" {
-			return fmt.Errorf("file is required")
+		key := cmd.String("from-bucket")
+		if (file == "") == (key == "") {
+			return fmt.Errorf("either a file or --from-bucket is required")
+		}
+		if key != "" {
+			if !discogs.DumpFilename(path.Base(key)).Valid() {
+				return fmt.Errorf("%s is not the name of a dump", key)
+			}
+			if !strings.Contains(key, "/") {
+				key = discogs.DumpFilename(key).Key()
+			}
+			file = filepath.Join(cmd.String("out-dir"), path.Base(key))
+			if _, err := os.Stat(file); err == nil {
+				return fmt.Errorf("%s was already downloaded, import it with db import %s", file, file)
+			}
+		}
+
+		// Importing a dump again truncates the tables, and takes a while.
+		database := databaseName(pool)
+		if catalog := commandCatalog(cmd, filepath.Dir(file)); catalog != nil && !cmd.Bool("force") {
+			if e, exists := catalog.Dumps[filepath.Base(file)]; exists {
+				if run, imported := e.imported(database); imported {
+					return fmt.Errorf("%s was already imported into %s on %s, use --force to import it again",
+						file, database, run.At.Local().Format(time.DateTime))
+				}
+			}
+		}
+
+		if err := pool.Ping(ctx); err != nil {
+			return err
 		}
 
 		dumpFile := discogs.DumpFilename(file)
@@ -51,17 +127,40 @@ var dbImportCmd = &cli.Command{
 		} else if dumpFile.Type() == "releases" {
 			modes = []int{discogs.ModeReleases, discogs.ModeReleasesArtists, discogs.ModeReleasesExtraArtists, discogs.ModeReleasesLabels}
 		}
+		if cmd.Bool("mentions") {
+			switch dumpFile.Type() {
+			case "artists":
+				modes = append(modes, discogs.ModeArtistsMentions)
+			case "labels":
+				modes = append(modes, discogs.ModeLabelsMentions)
+			case "masters":
+				modes = append(modes, discogs.ModeMastersMentions)
+			case "releases":
+				modes = append(modes, discogs.ModeReleasesMentions)
+			}
+		}
 
-		for _, mode := range modes {
-			table := discogs.Tables[mode]
-			fmt.Println("Truncating table", table.Sanitize())
-			conn.Exec(context.Background(), fmt.Sprintf("TRUNCATE TABLE %s CASCADE", discogs.Tables[mode].Sanitize()))
+		var normalizer *discogs.Normalizer
+		if cmd.Bool("normalize") {
+			normalizer = discogs.NewNormalizer()
 		}
 
 		now := time.Now()
-		CopyDiscogsDumpSinglePass(pool, file, modes)
+		var rows int64
+		if key != "" {
+			rows, err = importFromBucket(ctx, cmd, pool, key, file, modes, normalizer)
+		} else {
+			rows, err = CopyDiscogsDumpSinglePass(pool, file, modes, normalizer, cmd.String("markup"))
+		}
+		if err != nil {
+			return err
+		}
+		recordRun(cmd, file, catalogRun{At: now, Database: database, Rows: rows, Flags: commandFlags(cmd)})
 
 		fmt.Printf("Processed dump in %s.\n", time.Since(now))
+		if normalizer != nil {
+			reportUnparsed(normalizer.Unparsed())
+		}
 		return nil
 	},
 }
@@ -87,9 +186,32 @@ func CopyDiscogsDump(conn *pgx.Conn, filename string, mode int) (n int64, err er
 	return
 }
 
-func CopyDiscogsDumpSinglePass(pool *pgxpool.Pool, filename string, modes []int) error {
+// CopyDiscogsDumpSinglePass parses a dump once to copy its records to the
+// tables of modes, and returns the number of rows copied. It fails if the
+// dump can't be parsed or any of the copies fails.
+func CopyDiscogsDumpSinglePass(pool *pgxpool.Pool, filename string, modes []int, normalizer *discogs.Normalizer, markupFormat string) (int64, error) {
 	log.Printf("Processing %s in single-pass mode with %d tables.\n", filename, len(modes))
+
+	dd, err := discogs.OpenDumpFile(filename)
+	if err != nil {
+		return 0, err
+	}
+
+	return copyDumpSinglePass(pool, dd, modes, normalizer, markupFormat, nil)
+}
+
+// copyDumpSinglePass copies the records of a dump to the tables of modes,
+// each truncated and filled in a transaction of its own. The transactions
+// are committed once the whole dump is parsed and copied, so that the tables
+// are left untouched otherwise.
+//
+// Dumps streamed from the bucket are only verified once copied: with verify,
+// the records are copied to unlogged staging tables instead, and the tables
+// are replaced by them in a single transaction once verify succeeds.
+func copyDumpSinglePass(pool *pgxpool.Pool, dd *discogs.Dump, modes []int, normalizer *discogs.Normalizer, markupFormat string, verify func() error) (int64, error) {
+	ctx := context.Background()
 	now := time.Now()
+	normalized := normalizer != nil
 
 	channelMap := make(map[int]chan []any)
 	bufferSize := 1000
@@ -98,72 +220,197 @@ func CopyDiscogsDumpSinglePass(pool *pgxpool.Pool, filename string, modes []int)
 		channelMap[mode] = make(chan []any, bufferSize)
 	}
 
+	if verify != nil {
+		// The staging tables are dropped whether the import succeeds or not.
+		defer dropStagingTables(ctx, pool, modes)
+		if err := createStagingTables(ctx, pool, modes); err != nil {
+			return 0, err
+		}
+	}
+
 	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	var rows atomic.Int64
+	fail := func(err error) {
+		errOnce.Do(func() { firstErr = err })
+	}
 
 	wg.Add(1)
-	parser, err := discogs.NewMultiTableXMLParser(filename, channelMap, &wg)
-	if err != nil {
-		return err
-	}
+	parser := discogs.NewMultiTableXMLParserFromDump(dd, channelMap, &wg)
 	defer parser.Close()
+	parser.Normalizer = normalizer
+	parser.Markup = markupFormat
 
-	for _, mode := range modes {
+	// The transactions are rolled back unless committed.
+	txs := make([]pgx.Tx, len(modes))
+	defer func() {
+		for _, tx := range txs {
+			if tx != nil {
+				tx.Rollback(ctx)
+			}
+		}
+	}()
+
+	for i, mode := range modes {
 		wg.Add(1)
-		go func(mode int) {
+		go func() {
 			defer wg.Done()
+			// The records left are drained so that the parser isn't
+			// blocked by a failed copy.
+			defer func() {
+				for range channelMap[mode] {
+				}
+			}()
+
+			source := discogs.NewCopyFromRecordChannel(channelMap[mode])
+			columns := discogs.ImportColumns(mode, normalized)
+			if verify != nil {
+				n, err := pool.CopyFrom(ctx, stagingTable(mode), columns, source)
+				if err != nil {
+					fail(fmt.Errorf("CopyFrom failed for mode %d: %w", mode, err))
+					return
+				}
+				rows.Add(n)
+				log.Printf("Mode %d: processed %d rows", mode, n)
+				return
+			}
 
-			conn, err := pool.Acquire(context.Background())
+			tx, err := pool.Begin(ctx)
 			if err != nil {
-				log.Printf("Failed to acquire connection for mode %d: %v", mode, err)
+				fail(fmt.Errorf("failed to begin transaction for mode %d: %w", mode, err))
 				return
 			}
-			defer conn.Release()
+			txs[i] = tx
 
-			source := discogs.NewCopyFromRecordChannel(channelMap[mode])
-			n, err := conn.CopyFrom(context.Background(), discogs.Tables[mode], getColumnsForMode(mode), source)
+			table := discogs.Tables[mode]
+			fmt.Println("Truncating table", table.Sanitize())
+			if _, err := tx.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s CASCADE", table.Sanitize())); err != nil {
+				fail(fmt.Errorf("failed to truncate %s: %w", table.Sanitize(), err))
+				return
+			}
+
+			n, err := tx.CopyFrom(ctx, table, columns, source)
 			if err != nil {
-				log.Printf("CopyFrom failed for mode %d: %v", mode, err)
+				fail(fmt.Errorf("CopyFrom failed for mode %d: %w", mode, err))
 				return
 			}
+			rows.Add(n)
 			log.Printf("Mode %d: processed %d rows", mode, n)
-		}(mode)
+		}()
 	}
 
+	// The parser is done with the wait group once it has closed the
+	// channels, before it returns its error.
+	parseErr := make(chan error, 1)
 	go func() {
-		if err := parser.ParseAndDistribute(); err != nil {
-			log.Printf("Parser error: %v", err)
-		}
+		parseErr <- parser.ParseAndDistribute()
 	}()
 
 	wg.Wait()
+	if err := <-parseErr; err != nil {
+		return rows.Load(), fmt.Errorf("failed to parse the dump: %w", err)
+	}
+	if firstErr != nil {
+		return rows.Load(), firstErr
+	}
+	if verify != nil {
+		if err := verify(); err != nil {
+			return rows.Load(), err
+		}
+		if err := swapStagingTables(ctx, pool, modes, normalized); err != nil {
+			return rows.Load(), err
+		}
+	}
+	for i, tx := range txs {
+		if tx == nil {
+			continue
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return rows.Load(), fmt.Errorf("failed to commit mode %d: %w", modes[i], err)
+		}
+	}
+
 	duration := time.Since(now)
 	log.Printf("Single-pass processing completed in %s.\n", duration)
+	return rows.Load(), nil
+}
+
+// stagingTable is the table the records of a mode are copied to before
+// replacing the records of its table.
+func stagingTable(mode int) pgx.Identifier {
+	return pgx.Identifier{discogs.Tables[mode][0] + "_import"}
+}
+
+// createStagingTables creates the staging tables of modes, without the
+// indexes of their tables so that copying is faster. The staging tables left
+// by an interrupted import are replaced.
+func createStagingTables(ctx context.Context, pool *pgxpool.Pool, modes []int) error {
+	for _, mode := range modes {
+		staging := stagingTable(mode).Sanitize()
+		if _, err := pool.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", staging)); err != nil {
+			return fmt.Errorf("failed to drop %s: %w", staging, err)
+		}
+		sql := fmt.Sprintf("CREATE UNLOGGED TABLE %s (LIKE %s INCLUDING DEFAULTS)", staging, discogs.Tables[mode].Sanitize())
+		if _, err := pool.Exec(ctx, sql); err != nil {
+			return fmt.Errorf("failed to create %s: %w", staging, err)
+		}
+	}
+
 	return nil
 }
 
-func getColumnsForMode(mode int) []string {
-	switch mode {
-	case discogs.ModeArtists:
-		return discogs.Artist{}.Columns()
-	case discogs.ModeArtistsAliases:
-		return discogs.Artist{}.AliasesColumns()
-	case discogs.ModeArtistsMemberships:
-		return discogs.Artist{}.MembershipsColumns()
-	case discogs.ModeLabels:
-		return discogs.Label{}.Columns()
-	case discogs.ModeMasters:
-		return discogs.Master{}.Columns()
-	case discogs.ModeMastersArtists:
-		return discogs.Master{}.ArtistsColumns()
-	case discogs.ModeReleases:
-		return discogs.Release{}.Columns()
-	case discogs.ModeReleasesArtists:
-		return discogs.Release{}.ArtistsColumns()
-	case discogs.ModeReleasesExtraArtists:
-		return discogs.Release{}.ExtraArtistsColumns()
-	case discogs.ModeReleasesLabels:
-		return discogs.Release{}.LabelsColumns()
-	default:
-		return nil
+// swapStagingTables replaces the records of the tables of modes by the
+// records of their staging tables, in a single transaction. The columns filled
+// by a Normalizer are left out unless normalized.
+func swapStagingTables(ctx context.Context, pool *pgxpool.Pool, modes []int, normalized bool) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, mode := range modes {
+		table := discogs.Tables[mode].Sanitize()
+		fmt.Println("Replacing table", table)
+		if _, err := tx.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s CASCADE", table)); err != nil {
+			return fmt.Errorf("failed to truncate %s: %w", table, err)
+		}
+
+		importColumns := discogs.ImportColumns(mode, normalized)
+		columns := make([]string, 0, len(importColumns))
+		for _, column := range importColumns {
+			columns = append(columns, pgx.Identifier{column}.Sanitize())
+		}
+		sql := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", table, strings.Join(columns, ", "),
+			strings.Join(columns, ", "), stagingTable(mode).Sanitize())
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", table, err)
+		}
 	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit the import: %w", err)
+	}
+
+	return nil
+}
+
+// dropStagingTables drops the staging tables of modes. Failing to drop them
+// doesn't fail the import, the next one replaces them.
+func dropStagingTables(ctx context.Context, pool *pgxpool.Pool, modes []int) {
+	for _, mode := range modes {
+		staging := stagingTable(mode).Sanitize()
+		if _, err := pool.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", staging)); err != nil {
+			log.Printf("Cannot drop %s: %v\n", staging, err)
+		}
+	}
+}
+
+// databaseName identifies the database of a pool in the catalog, as
+// host:port/name, leaving the credentials out.
+func databaseName(pool *pgxpool.Pool) string {
+	config := pool.Config().ConnConfig
+
+	return fmt.Sprintf("%s:%d/%s", config.Host, config.Port, config.Database)
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/urfave/cli/v3"
+)
+
+// importFromBucket imports the dump at key in the bucket in a single pass:
+// the body of the response is decoded while it's written to file.part and
+// hashed. The import is committed, and the part renamed to file, only if the
+// checksum of the dump matches the one listed in its CHECKSUM file.
+//
+// The part of a failed import is kept with its ETag, so that dump download
+// resumes it.
+func importFromBucket(ctx context.Context, cmd *cli.Command, pool *pgxpool.Pool, key, file string, modes []int, normalizer *discogs.Normalizer) (int64, error) {
+	client, err := newBucketClient(cmd)
+	if err != nil {
+		return 0, err
+	}
+	fn := discogs.DumpFilename(path.Base(key))
+
+	checksums, err := downloadChecksums(ctx, client, path.Join(path.Dir(key), fn.ChecksumFilename()), filepath.Dir(file), false, defaultDownloadOptions)
+	if err != nil {
+		return 0, err
+	}
+	expected, exists := checksums.Lookup(fn.String())
+	if !exists {
+		return 0, fmt.Errorf("%s is not listed in %s", fn, fn.ChecksumFilename())
+	}
+
+	resp, err := client.Get(ctx, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	url := resp.Request.URL.String()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	part := file + ".part"
+	f, err := openPart(part, 0, resp.Header.Get("ETag"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	body := io.TeeReader(resp.Body, io.MultiWriter(f, h))
+	dd, err := discogs.NewDump(body, fn.Gzipped())
+	if err != nil {
+		return 0, err
+	}
+
+	verify := func() error {
+		// The decoder stops at the end of the XML document, which may
+		// come before the end of the body.
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		sum := hex.EncodeToString(h.Sum(nil))
+		if !strings.EqualFold(sum, expected) {
+			return discardPart(part, fmt.Errorf("checksum mismatch for %s: expected %s, got %s, nothing was imported", url, expected, sum))
+		}
+		if err := os.Rename(part, file); err != nil {
+			return err
+		}
+		recordDownload(cmd, file, key, sum, true)
+
+		return removeIfExists(part + ".etag")
+	}
+
+	log.Printf("Importing %s while downloading it to %s.\n", url, file)
+
+	return copyDumpSinglePass(pool, dd, modes, normalizer, cmd.String("markup"), verify)
+}
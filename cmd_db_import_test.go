@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/marcw/dgtools/internal/discogs"
+)
+
+// testPool connects to the database of DGTOOLS_TEST_DATABASE_URL, whose
+// tables are truncated by the tests, and prepares it.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	url := os.Getenv("DGTOOLS_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("DGTOOLS_TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := migrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	return pool
+}
+
+// A dump that can't be parsed to its end leaves the tables untouched, whether
+// it's imported from a file or from the bucket.
+func TestCopyDumpTruncated(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	dump := filepath.Join(t.TempDir(), "discogs_20250101_labels.xml")
+	truncated := `<labels><label><id>2</id><name>Imported</name></label><label><id>3</id><name>Trunc`
+	if err := os.WriteFile(dump, []byte(truncated), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		verify func() error
+	}{
+		{name: "file"},
+		{name: "bucket", verify: func() error { return nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := pool.Exec(ctx, "TRUNCATE TABLE discogs_labels CASCADE"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := pool.Exec(ctx, "INSERT INTO discogs_labels (id, name) VALUES (1, 'Kept')"); err != nil {
+				t.Fatal(err)
+			}
+
+			dd, err := discogs.OpenDumpFile(dump)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = copyDumpSinglePass(pool, dd, []int{discogs.ModeLabels}, nil, "", tt.verify)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			rows, err := pool.Query(ctx, "SELECT id FROM discogs_labels ORDER BY id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ids, []int64{1}) {
+				t.Errorf("got labels %v, want the label imported before only", ids)
+			}
+		})
+	}
+}
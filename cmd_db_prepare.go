@@ -27,13 +27,7 @@ var dbPrepareCmd = &cli.Command{
 		}
 		defer db.Close()
 
-		goose.SetBaseFS(embedMigrations)
-
-		if err := goose.SetDialect("postgres"); err != nil {
-			return err
-		}
-
-		if err := goose.Up(db, "migrations/pg"); err != nil {
+		if err := migrateDatabase(db); err != nil {
 			return err
 		}
 
@@ -42,3 +36,14 @@ var dbPrepareCmd = &cli.Command{
 		return nil
 	},
 }
+
+// migrateDatabase applies the migrations the database is missing.
+func migrateDatabase(db *sql.DB) error {
+	goose.SetBaseFS(embedMigrations)
+
+	if err := goose.SetDialect("postgres"); err != nil {
+		return err
+	}
+
+	return goose.Up(db, "migrations/pg")
+}
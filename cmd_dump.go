@@ -9,6 +9,11 @@ var dumpCmd = &cli.Command{
 		discogsDumpListCmd,
 		discogsDumpStructureCmd,
 		discogsDumpDownloadCmd,
+		discogsDumpVerifyCmd,
+		discogsDumpSyncCmd,
+		discogsDumpCatalogCmd,
 		discogsDumpConvertCmd,
+		discogsDumpESMappingCmd,
+		discogsDumpGraphCmd,
 	},
 }
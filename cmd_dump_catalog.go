@@ -0,0 +1,371 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/dustin/go-humanize"
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/urfave/cli/v3"
+)
+
+// catalogFilename is the name of the catalog kept in each directory of dumps.
+const catalogFilename = "dgtools-catalog.json"
+
+const (
+	CatalogUnverified = "unverified"
+	CatalogVerified   = "verified"
+	CatalogFailed     = "failed"
+)
+
+var discogsDumpCatalogCmd = &cli.Command{
+	Name:  "catalog",
+	Usage: "Show the catalog of the dumps of a directory",
+	Description: "The catalog records the dumps downloaded, verified, converted and imported from a directory, " +
+		"with their size and checksum. A dump modified since it was recorded is shown as such.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "dir",
+			Usage: "The directory of the dumps",
+			Value: ".",
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: fmt.Sprintf("The output format: %s", strings.Join(listOutputs, ", ")),
+			Value: ListOutputTable,
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if !slices.Contains(listOutputs, s) {
+					return fmt.Errorf("supported outputs are: %s", strings.Join(listOutputs, ", "))
+				}
+				return nil
+			},
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		dir := cmd.String("dir")
+		c, err := readCatalog(dir)
+		if err != nil {
+			return err
+		}
+
+		entries := make([]*catalogEntry, 0, len(c.Dumps))
+		for _, e := range c.Dumps {
+			entries = append(entries, e)
+		}
+		slices.SortFunc(entries, func(a, b *catalogEntry) int {
+			return cmp.Compare(a.Name, b.Name)
+		})
+		for _, e := range entries {
+			e.Status = e.status(dir)
+		}
+
+		switch cmd.String("output") {
+		case ListOutputJSON:
+			return printJSON(entries)
+		case ListOutputCSV:
+			rows := make([][]string, len(entries))
+			for i, e := range entries {
+				rows[i] = []string{e.Name, strconv.FormatInt(e.Size, 10), e.SHA256, e.Status,
+					formatCatalogTime(e.DownloadedAt, time.RFC3339), summarizeRuns(e.Conversions), summarizeRuns(e.Imports)}
+			}
+			return printCSV([]string{"name", "size", "sha256", "status", "downloaded_at", "conversions", "imports"}, rows)
+		default:
+			t := table.New().Headers("NAME", "SIZE", "STATUS", "DOWNLOADED", "CONVERSIONS", "IMPORTS")
+			for _, e := range entries {
+				t.Row(e.Name, humanize.Bytes(uint64(e.Size)), e.Status, formatCatalogTime(e.DownloadedAt, time.DateTime),
+					summarizeRuns(e.Conversions), summarizeRuns(e.Imports))
+			}
+			fmt.Println(t)
+			return nil
+		}
+	},
+}
+
+// dumpCatalog records the dumps of a directory and what was done with them,
+// so that commands can skip redundant work.
+type dumpCatalog struct {
+	Dumps map[string]*catalogEntry `json:"dumps"`
+}
+
+// catalogEntry is what's known about a dump file.
+type catalogEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// ModTime is the modification time of the file when it was recorded.
+	// The verification of a file modified since doesn't hold anymore.
+	ModTime time.Time `json:"mod_time"`
+	// SHA256 is the checksum computed when the file was downloaded or
+	// verified.
+	SHA256       string     `json:"sha256,omitempty"`
+	Verification string     `json:"verification"`
+	VerifiedAt   *time.Time `json:"verified_at,omitempty"`
+	DownloadedAt *time.Time `json:"downloaded_at,omitempty"`
+	// Source is the key of the dump in the bucket, or in any of its
+	// mirrors.
+	Source      string       `json:"source,omitempty"`
+	Conversions []catalogRun `json:"conversions,omitempty"`
+	Imports     []catalogRun `json:"imports,omitempty"`
+
+	// Status is the verification, or whether the file is missing or was
+	// modified, as shown by dump catalog.
+	Status string `json:"status,omitempty"`
+}
+
+// catalogRun is a conversion or an import of a dump.
+type catalogRun struct {
+	At       time.Time         `json:"at"`
+	Format   string            `json:"format,omitempty"`
+	Output   string            `json:"output,omitempty"`
+	Database string            `json:"database,omitempty"`
+	Rows     int64             `json:"rows"`
+	Flags    map[string]string `json:"flags,omitempty"`
+}
+
+// catalogMu serializes the updates of the catalogs by concurrent downloads
+// and conversions.
+var catalogMu sync.Mutex
+
+// readCatalog reads the catalog of a directory, empty if there's none yet.
+func readCatalog(dir string) (*dumpCatalog, error) {
+	c := &dumpCatalog{}
+	data, err := os.ReadFile(filepath.Join(dir, catalogFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("invalid catalog %s: %w", filepath.Join(dir, catalogFilename), err)
+		}
+	}
+	if c.Dumps == nil {
+		c.Dumps = make(map[string]*catalogEntry)
+	}
+
+	return c, nil
+}
+
+// write replaces the catalog of a directory atomically.
+func (c *dumpCatalog) write(dir string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, catalogFilename+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, catalogFilename))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// verified returns true if the file described by info was verified to have
+// the checksum sha256 and wasn't modified since. c may be nil.
+func (c *dumpCatalog) verified(info os.FileInfo, sha256 string) bool {
+	if c == nil || sha256 == "" {
+		return false
+	}
+	e, exists := c.Dumps[info.Name()]
+
+	return exists && !e.modified(info) && e.Verification == CatalogVerified && strings.EqualFold(e.SHA256, sha256)
+}
+
+func (e *catalogEntry) modified(info os.FileInfo) bool {
+	return e.Size != info.Size() || !e.ModTime.Equal(info.ModTime())
+}
+
+// status returns the verification of the file, or whether it is missing or
+// was modified since it was recorded.
+func (e *catalogEntry) status(dir string) string {
+	info, err := os.Stat(filepath.Join(dir, e.Name))
+	switch {
+	case err != nil:
+		return "missing"
+	case e.modified(info):
+		return "modified"
+	default:
+		return e.Verification
+	}
+}
+
+// imported returns the last import of the dump into database, if any.
+func (e *catalogEntry) imported(database string) (catalogRun, bool) {
+	for i := len(e.Imports) - 1; i >= 0; i-- {
+		if e.Imports[i].Database == database {
+			return e.Imports[i], true
+		}
+	}
+
+	return catalogRun{}, false
+}
+
+// summarizeRuns returns the formats or the databases of conversions or
+// imports.
+func summarizeRuns(runs []catalogRun) string {
+	targets := make([]string, 0, len(runs))
+	for _, run := range runs {
+		target := cmp.Or(run.Database, run.Format)
+		if !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+
+	return strings.Join(targets, ", ")
+}
+
+func formatCatalogTime(t *time.Time, layout string) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Local().Format(layout)
+}
+
+// updateCatalog updates the catalog entry of a dump file, refreshing its
+// size and modification time. The verification of a file modified since it
+// was recorded is reset.
+func updateCatalog(filename string, update func(e *catalogEntry)) error {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(filename)
+	c, err := readCatalog(dir)
+	if err != nil {
+		return err
+	}
+
+	e, exists := c.Dumps[info.Name()]
+	if !exists {
+		e = &catalogEntry{Name: info.Name(), Verification: CatalogUnverified}
+		c.Dumps[info.Name()] = e
+	}
+	if e.modified(info) {
+		e.SHA256 = ""
+		e.Verification = CatalogUnverified
+		e.VerifiedAt = nil
+	}
+	e.Size = info.Size()
+	e.ModTime = info.ModTime()
+	update(e)
+
+	return c.write(dir)
+}
+
+// commandCatalog reads the catalog of a directory, or returns nil with
+// --no-catalog or if it can't be read, in which case nothing is skipped.
+func commandCatalog(cmd *cli.Command, dir string) *dumpCatalog {
+	if cmd.Bool("no-catalog") {
+		return nil
+	}
+	c, err := readCatalog(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring the catalog: %v\n", err)
+		return nil
+	}
+
+	return c
+}
+
+// recordInCatalog updates the catalog entry of a dump file, unless
+// --no-catalog is set or the file isn't a dump. Failing to update the
+// catalog doesn't fail the command.
+func recordInCatalog(cmd *cli.Command, filename string, update func(e *catalogEntry)) {
+	if cmd.Bool("no-catalog") || !discogs.DumpFilename(filepath.Base(filename)).Valid() {
+		return
+	}
+	if err := updateCatalog(filename, update); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot update the catalog of %s: %v\n", filename, err)
+	}
+}
+
+// removeDump removes a dump file and its catalog entry, which is left as is
+// with --no-catalog. Failing to update the catalog doesn't fail the command.
+func removeDump(cmd *cli.Command, filename string) error {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	if err := os.Remove(filename); err != nil {
+		return err
+	}
+	if cmd.Bool("no-catalog") {
+		return nil
+	}
+	dir := filepath.Dir(filename)
+	c, err := readCatalog(dir)
+	if err == nil {
+		if _, exists := c.Dumps[filepath.Base(filename)]; !exists {
+			return nil
+		}
+		delete(c.Dumps, filepath.Base(filename))
+		err = c.write(dir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot update the catalog of %s: %v\n", filename, err)
+	}
+
+	return nil
+}
+
+// recordVerification records the checksum of a dump file and whether it
+// matched the one expected.
+func recordVerification(cmd *cli.Command, filename, sha256 string, ok bool) {
+	recordInCatalog(cmd, filename, func(e *catalogEntry) {
+		now := time.Now()
+		e.SHA256 = sha256
+		e.Verification = CatalogFailed
+		if ok {
+			e.Verification = CatalogVerified
+		}
+		e.VerifiedAt = &now
+	})
+}
+
+// recordRun records a conversion of a dump file, or an import when the run
+// has a database.
+func recordRun(cmd *cli.Command, filename string, run catalogRun) {
+	recordInCatalog(cmd, filename, func(e *catalogEntry) {
+		if run.Database != "" {
+			e.Imports = append(e.Imports, run)
+		} else {
+			e.Conversions = append(e.Conversions, run)
+		}
+	})
+}
+
+// recordDownload records the download of a dump file from key in the bucket,
+// verified if its checksum was known beforehand.
+func recordDownload(cmd *cli.Command, filename, key, sha256 string, verified bool) {
+	recordInCatalog(cmd, filename, func(e *catalogEntry) {
+		now := time.Now()
+		e.DownloadedAt = &now
+		e.Source = key
+		e.SHA256 = sha256
+		if verified {
+			e.Verification = CatalogVerified
+			e.VerifiedAt = &now
+		}
+	})
+}
@@ -1,24 +1,34 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"runtime"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/briandowns/spinner"
 	"github.com/marcw/dgtools/internal/discogs"
-	"github.com/parquet-go/parquet-go"
+	"github.com/marcw/dgtools/internal/discogs/markup"
 	"github.com/urfave/cli/v3"
 )
 
 const (
-	FormatParquet = "parquet"
-	FormatNdjson  = "ndjson"
+	FormatParquet  = "parquet"
+	FormatNdjson   = "ndjson"
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+	FormatESBulk   = "es-bulk"
+	FormatNTriples = "ntriples"
+	FormatJSONLD   = "jsonld"
 )
 
+var formats = []string{FormatParquet, FormatNdjson, FormatJSON, FormatProtobuf, FormatESBulk, FormatNTriples, FormatJSONLD}
+
 var discogsDumpConvertCmd = &cli.Command{
 	Name:  "convert",
 	Usage: "Convert a Discogs data dump to a different format",
@@ -34,8 +44,8 @@ var discogsDumpConvertCmd = &cli.Command{
 			Usage: "Sets the output format for the conversion",
 			Value: FormatParquet,
 			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
-				if s != FormatParquet && s != FormatNdjson {
-					return fmt.Errorf("supported formats are: %s, %s", FormatParquet, FormatNdjson)
+				if !slices.Contains(formats, s) {
+					return fmt.Errorf("supported formats are: %s", strings.Join(formats, ", "))
 				}
 				return nil
 			},
@@ -49,11 +59,183 @@ var discogsDumpConvertCmd = &cli.Command{
 			Name:  "out",
 			Usage: "Save the converted output to file",
 		},
+		&cli.StringFlag{
+			Name:  "month",
+			Usage: "Convert the four dumps of a month (YYYY-MM) found in --in-dir",
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if _, err := time.Parse("2006-01", s); err != nil {
+					return fmt.Errorf("month must be formatted as YYYY-MM")
+				}
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:  "in-dir",
+			Usage: "Directory where the dumps of --month are looked up",
+			Value: ".",
+		},
+		&cli.StringFlag{
+			Name:  "out-dir",
+			Usage: "Directory where the dumps of --month are converted, along with a manifest",
+		},
+		&cli.StringFlag{
+			Name:    "s3-endpoint",
+			Usage:   "Endpoint of the S3-compatible storage of s3:// outputs",
+			Value:   "https://s3.amazonaws.com",
+			Sources: cli.EnvVars("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+		},
+		&cli.StringFlag{
+			Name:    "s3-region",
+			Usage:   "Region of the bucket of s3:// outputs",
+			Sources: cli.EnvVars("AWS_REGION", "AWS_DEFAULT_REGION"),
+		},
+		&cli.BoolFlag{
+			Name:  "s3-path-style",
+			Usage: "Use path-style addressing for s3:// outputs, as required by MinIO",
+			Value: false,
+		},
+		&cli.Int64Flag{
+			Name:  "s3-part-size",
+			Usage: "Size in bytes of the parts uploaded to s3:// outputs, buffered in memory for each open file",
+			Value: 16 << 20,
+			Action: func(ctx context.Context, cmd *cli.Command, v int64) error {
+				if v < 5<<20 {
+					return fmt.Errorf("--s3-part-size must be at least 5 MiB")
+				}
+				return nil
+			},
+		},
 		&cli.Int64Flag{
 			Name:  "stop-after",
 			Usage: "Stop conversion after X records",
 			Value: 0,
 		},
+		&cli.IntFlag{
+			Name:  "workers",
+			Usage: "Number of goroutines decoding and encoding records, 1 converts sequentially",
+			Value: runtime.GOMAXPROCS(0),
+		},
+		&cli.StringFlag{
+			Name:  "compress",
+			Usage: fmt.Sprintf("Compress ndjson and json output (%s)", strings.Join(outputCompressions, ", ")),
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if !slices.Contains(outputCompressions, s) {
+					return fmt.Errorf("supported output compressions are: %s", strings.Join(outputCompressions, ", "))
+				}
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:  "pretty",
+			Usage: "Pretty-print json output",
+			Value: false,
+		},
+		&cli.StringFlag{
+			Name:  "es-index",
+			Usage: "Name of the index of es-bulk actions, when not given in the _bulk URL",
+		},
+		&cli.BoolFlag{
+			Name:  "json-v2",
+			Usage: "Marshal ndjson and json output with encoding/json/v2, requires a build with GOEXPERIMENT=jsonv2",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "normalize",
+			Usage: "Add the values parsed from release dates, track durations and track positions, unparseable values are kept verbatim and reported",
+			Value: false,
+		},
+		&cli.StringFlag{
+			Name:  "markup",
+			Usage: fmt.Sprintf("Render the markup of profiles and notes as %s, kept as is otherwise", strings.Join(markup.Formats, ", ")),
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if !slices.Contains(markup.Formats, s) {
+					return fmt.Errorf("supported markup formats are: %s", strings.Join(markup.Formats, ", "))
+				}
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:  "mentions",
+			Usage: "Write the artists, labels, releases and masters referenced by ID in profiles and notes to a <out>_mentions file",
+			Value: false,
+		},
+		&cli.StringSliceFlag{
+			Name:  "fields",
+			Usage: "Only output the given fields, nested fields are selected with dotted paths such as labels.catno",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude-fields",
+			Usage: "Don't output the given fields, nested fields are selected with dotted paths such as tracklist.extra_artists",
+		},
+		&cli.StringFlag{
+			Name:  "partition-by",
+			Usage: fmt.Sprintf("Write a Hive-partitioned directory tree to --out, partitioned by %s", strings.Join(partitionKeys, ", ")),
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if !slices.Contains(partitionKeys, s) {
+					return fmt.Errorf("supported partition keys are: %s", strings.Join(partitionKeys, ", "))
+				}
+				return nil
+			},
+		},
+		&cli.Int64Flag{
+			Name:  "max-rows-per-file",
+			Usage: "Roll to a new part file in --out after X rows",
+			Value: 0,
+		},
+		&cli.Int64Flag{
+			Name:  "max-bytes-per-file",
+			Usage: "Roll to a new part file in --out after approximately X bytes",
+			Value: 0,
+		},
+		&cli.IntFlag{
+			Name:  "max-open-partitions",
+			Usage: "The maximum number of partitions with a part file open, the least recently written one rolling to a new part file beyond",
+			Value: 32,
+			Action: func(ctx context.Context, cmd *cli.Command, v int) error {
+				if v < 1 {
+					return fmt.Errorf("--max-open-partitions must be at least 1")
+				}
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:  "compression",
+			Usage: fmt.Sprintf("Parquet compression codec (%s)", strings.Join(parquetCodecs, ", ")),
+			Value: CodecZstd,
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if !slices.Contains(parquetCodecs, s) {
+					return fmt.Errorf("supported compression codecs are: %s", strings.Join(parquetCodecs, ", "))
+				}
+				return nil
+			},
+		},
+		&cli.IntFlag{
+			Name:  "compression-level",
+			Usage: "Parquet compression level, the codec's default when not set",
+			Action: func(ctx context.Context, cmd *cli.Command, v int) error {
+				return validateCompressionLevel(cmd.String("compression"), v)
+			},
+		},
+		&cli.Int64Flag{
+			Name:  "row-group-size",
+			Usage: "Maximum number of rows per parquet row group, 0 for unlimited",
+			Value: 1000000,
+		},
+		&cli.IntFlag{
+			Name:  "page-size",
+			Usage: "Size in bytes of the parquet page buffers, 0 uses the library default",
+			Value: 0,
+		},
+		&cli.BoolFlag{
+			Name:  "sort-by-id",
+			Usage: "Sort parquet rows by id within each row group",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "bloom-filters",
+			Usage: "Write bloom filters for the parquet id columns",
+			Value: true,
+		},
 	},
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		outputFormat := cmd.String("format")
@@ -61,43 +243,93 @@ var discogsDumpConvertCmd = &cli.Command{
 		inputFile := cmd.StringArg("name")
 		noProgress := cmd.Bool("no-progress")
 
-		i := int64(0)
-		var outFile *os.File
-		var parquetWriter *parquet.Writer
-
 		// First, we validate the arguments and flags.
-		if inputFile == "" {
-			return fmt.Errorf("input file is required")
+		options := outputOptions{
+			Format:            outputFormat,
+			Out:               outputFile,
+			PartitionBy:       cmd.String("partition-by"),
+			MaxRowsPerFile:    cmd.Int64("max-rows-per-file"),
+			MaxBytesPerFile:   cmd.Int64("max-bytes-per-file"),
+			MaxOpenPartitions: cmd.Int("max-open-partitions"),
+			Fields:            newFieldSelection(cmd.StringSlice("fields"), cmd.StringSlice("exclude-fields")),
+			Compress:          cmd.String("compress"),
+			Pretty:            cmd.Bool("pretty"),
+			JSONv2:            cmd.Bool("json-v2"),
+			ESIndex:           cmd.String("es-index"),
+			Markup:            cmd.String("markup"),
+			Mentions:          cmd.Bool("mentions"),
+			Parquet: parquetOptions{
+				Codec:        cmd.String("compression"),
+				RowGroupSize: cmd.Int64("row-group-size"),
+				PageSize:     cmd.Int("page-size"),
+				SortByID:     cmd.Bool("sort-by-id"),
+				BloomFilters: cmd.Bool("bloom-filters"),
+			},
+		}
+		if cmd.IsSet("compression-level") {
+			level := cmd.Int("compression-level")
+			options.Parquet.Level = &level
+		}
+		if cmd.Bool("normalize") {
+			options.Normalizer = discogs.NewNormalizer()
 		}
 
-		// do not output binary things to stdout
-		if outputFormat == FormatParquet && outputFile == "" {
-			return fmt.Errorf("output file is required for parquet format conversion")
+		if outputFormat == FormatParquet && options.Compress != "" {
+			return fmt.Errorf("--compress is not supported for parquet, use --compression instead")
 		}
-		// if we convert to ndjson, we don't output progress
-		if outputFormat == FormatNdjson && outputFile == "" {
-			noProgress = true
+		if outputFormat != FormatJSON && options.Pretty {
+			return fmt.Errorf("--pretty is only supported for json format conversion")
+		}
+		// messages and resources are built from the entity types, not from
+		// projections
+		if (outputFormat == FormatProtobuf || outputFormat == FormatNTriples || outputFormat == FormatJSONLD) && options.Fields != nil {
+			return fmt.Errorf("--fields and --exclude-fields are not supported for %s format conversion", outputFormat)
+		}
+
+		// mentions are rows of their own, which only these formats can hold
+		if options.Mentions && outputFormat != FormatParquet && outputFormat != FormatNdjson && outputFormat != FormatJSON {
+			return fmt.Errorf("--mentions is only supported for parquet, ndjson and json format conversion")
+		}
+
+		if month := cmd.String("month"); month != "" {
+			if inputFile != "" || outputFile != "" {
+				return fmt.Errorf("--month converts the dumps found in --in-dir to --out-dir, it can't be used with a file name or --out")
+			}
+			return convertMonth(ctx, cmd, month, options)
+		}
+		if inputFile == "" {
+			return fmt.Errorf("input file is required")
 		}
 
-		dump, err := discogs.OpenDumpFile(inputFile)
+		storage, out, err := newOutputStorage(cmd, outputFile)
 		if err != nil {
 			return err
 		}
-		defer dump.Close()
+		// s3://bucket would otherwise be written to stdout
+		if outputFile != "" && out == "" {
+			return fmt.Errorf("missing key in %s", outputFile)
+		}
+		options.Storage = storage
+		options.Out = out
 
-		if outputFile != "" {
-			outFile, err := os.Create(outputFile)
-			if err != nil {
-				return err
-			}
-			defer outFile.Close()
-		} else {
-			outFile = os.Stdout
+		// do not output binary things to stdout
+		if outputFormat == FormatParquet && options.Out == "" {
+			return fmt.Errorf("output file is required for parquet format conversion")
+		}
+		if options.directory() && options.Out == "" {
+			return fmt.Errorf("output directory is required for partitioned or rolled output")
+		}
+		if options.Mentions && options.Out == "" {
+			return fmt.Errorf("output file is required to write mentions next to it")
+		}
+		// if we convert to json on stdout, we don't output progress
+		if outputFormat != FormatParquet && options.Out == "" {
+			noProgress = true
 		}
 
-		if outputFormat == FormatParquet {
-			parquetWriter := parquet.NewWriter(outFile)
-			defer parquetWriter.Close()
+		// the standard output has no sidecar
+		if options.Out != "" {
+			options.Provenance = newSourceProvenance(inputFile, commandFlags(cmd))
 		}
 
 		s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
@@ -106,66 +338,167 @@ var discogsDumpConvertCmd = &cli.Command{
 			s.Start()
 		}
 		now := time.Now()
-		for {
-			element, err := dump.DecodeNextElement()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return err
-			}
-			if element == nil {
-				continue
-			}
 
-			switch outputFormat {
-			case FormatParquet:
-				if err := parquetWriter.Write(element); err != nil {
-					return err
-				}
-			case FormatNdjson:
-				b, err := json.Marshal(element)
-				if err != nil {
-					return err
-				}
-				if _, err := outFile.Write(b); err != nil {
-					return err
-				}
-				if _, err := outFile.Write([]byte("\n")); err != nil {
-					return err
-				}
+		rows, err := convertDump(ctx, inputFile, options, cmd.Int("workers"), cmd.Int64("stop-after"), func(rows int64) {
+			if !noProgress {
+				s.Suffix = fmt.Sprintf(" Converting... %d", rows)
 			}
+		})
+		if err != nil {
+			return err
+		}
+		recordRun(cmd, inputFile, catalogRun{At: now, Format: outputFormat, Output: cmp.Or(outputFile, "-"), Rows: rows, Flags: commandFlags(cmd)})
 
-			i++
-			if cmd.Int64("stop-after") != 0 && i >= cmd.Int64("stop-after") {
-				break
-			}
-			if !noProgress && i%1000 == 0 {
-				s.Suffix = fmt.Sprintf(" Converting... %d", i)
-			}
+		if !noProgress {
+			s.Stop()
+			fmt.Printf("Converted %d rows in %s.\n", rows, time.Since(now))
+		}
+		if options.Normalizer != nil {
+			reportUnparsed(options.Normalizer.Unparsed())
 		}
 
-		switch outputFormat {
-		case FormatParquet:
-			if err := parquetWriter.Flush(); err != nil {
-				return err
+		return nil
+	},
+}
+
+// convertDump converts a dump file to the output described by options and
+// returns the number of rows converted. progress is called every 1000 rows.
+func convertDump(ctx context.Context, inputFile string, options outputOptions, workers int, stopAfter int64, progress func(rows int64)) (int64, error) {
+	file, err := os.Open(inputFile)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var source io.Reader = file
+	if options.Provenance != nil {
+		source = options.Provenance.reader(file)
+	}
+	dump, err := discogs.NewDump(source, strings.HasSuffix(inputFile, ".gz"))
+	if err != nil {
+		return 0, err
+	}
+	defer dump.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer, err := newOutputWriter(ctx, options)
+	if err != nil {
+		return 0, err
+	}
+	var mentionsWriter elementWriter
+	if options.Mentions {
+		mentionsWriter, err = newOutputWriter(ctx, options.mentionsOptions())
+		if err != nil {
+			cancel()
+			writer.Close()
+			return 0, err
+		}
+	}
+
+	i := int64(0)
+	write := func(element any, mentions []discogs.Mention) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if err := writer.Write(element); err != nil {
+			return false, err
+		}
+		for j := range mentions {
+			if err := mentionsWriter.Write(&mentions[j]); err != nil {
+				return false, err
 			}
-		case FormatNdjson:
-			if _, err := outFile.Write([]byte("\n")); err != nil {
+		}
+
+		i++
+		if stopAfter != 0 && i >= stopAfter {
+			return false, nil
+		}
+		if i%1000 == 0 {
+			progress(i)
+		}
+		return true, nil
+	}
+
+	if workers > 1 {
+		pipeline := newConversionPipeline(dump, options, workers)
+		err = pipeline.Run(ctx, func(element *preparedElement) (bool, error) {
+			return write(element, element.Mentions)
+		})
+	} else {
+		err = convertSequentially(dump, options, write)
+	}
+	if err == nil && options.Provenance != nil {
+		err = options.Provenance.finish(stopAfter == 0 || i < stopAfter)
+	}
+	if err != nil {
+		// Files being uploaded are aborted rather than completed.
+		cancel()
+		writer.Close()
+		if mentionsWriter != nil {
+			mentionsWriter.Close()
+		}
+		return i, err
+	}
+
+	if err := writer.Close(); err != nil {
+		if mentionsWriter != nil {
+			cancel()
+			mentionsWriter.Close()
+		}
+		return i, err
+	}
+	if mentionsWriter != nil {
+		if err := mentionsWriter.Close(); err != nil {
+			return i, err
+		}
+	}
+
+	return i, nil
+}
+
+// reportUnparsed prints the values the normalizer couldn't parse to stderr,
+// so that they don't end up in converted output written to stdout.
+func reportUnparsed(unparsed []discogs.UnparsedValues) {
+	for _, values := range unparsed {
+		fmt.Fprintf(os.Stderr, "%s.\n", values)
+	}
+}
+
+// convertSequentially decodes the dump and calls write with every element and
+// its mentions until it returns false.
+func convertSequentially(dump *discogs.Dump, options outputOptions, write func(element any, mentions []discogs.Mention) (bool, error)) error {
+	first := true
+	for {
+		element, err := dump.DecodeNextElement()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if element == nil {
+			continue
+		}
+		mentions, err := options.prepareElement(element)
+		if err != nil {
+			return err
+		}
+
+		// Once the projection is known, subtrees that are neither
+		// projected nor partitioned on don't need to be decoded anymore.
+		if first {
+			skip, err := options.skippedElements(element)
+			if err != nil {
 				return err
 			}
-			if outFile != os.Stdout {
-				if err := outFile.Sync(); err != nil {
-					return err
-				}
-			}
+			dump.SkipElements(skip...)
+			first = false
 		}
 
-		if !noProgress {
-			s.Stop()
-			fmt.Printf("Converted %d rows in %s.\n", i, time.Since(now))
+		more, err := write(element, mentions)
+		if err != nil || !more {
+			return err
 		}
-
-		return nil
-	},
+	}
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/marcw/dgtools/internal/discogs"
+)
+
+// esBulkWriter writes Elasticsearch and OpenSearch _bulk requests: an index
+// action keyed by the entity ID, followed by the document.
+type esBulkWriter struct {
+	w       io.Writer
+	index   string
+	encoder *json.Encoder
+}
+
+func newESBulkWriter(w io.Writer, index string) *esBulkWriter {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	return &esBulkWriter{w: w, index: index, encoder: encoder}
+}
+
+type esBulkAction struct {
+	Index esBulkActionMetadata `json:"index"`
+}
+
+type esBulkActionMetadata struct {
+	Index string `json:"_index,omitempty"`
+	ID    string `json:"_id"`
+}
+
+func (w *esBulkWriter) Write(element any) error {
+	id, err := entityID(unwrapElement(element))
+	if err != nil {
+		return err
+	}
+	if err := w.encoder.Encode(esBulkAction{Index: esBulkActionMetadata{Index: w.index, ID: fmt.Sprint(id)}}); err != nil {
+		return err
+	}
+
+	if prepared, ok := element.(*preparedElement); ok {
+		if _, err := w.w.Write(prepared.JSON); err != nil {
+			return err
+		}
+		_, err := w.w.Write([]byte("\n"))
+		return err
+	}
+
+	return w.encoder.Encode(element)
+}
+
+func (w *esBulkWriter) Close() error {
+	return nil
+}
+
+// entityID returns the Discogs ID of a decoded element.
+func entityID(element any) (int64, error) {
+	switch e := element.(type) {
+	case *discogs.Artist:
+		return e.ID, nil
+	case *discogs.Label:
+		return e.ID, nil
+	case *discogs.Master:
+		return e.ID, nil
+	case *discogs.Release:
+		return e.ID, nil
+	}
+
+	// Projected elements keep the names of the original fields.
+	v := reflect.Indirect(reflect.ValueOf(element))
+	if v.Kind() == reflect.Struct {
+		if id := v.FieldByName("ID"); id.IsValid() && id.Kind() == reflect.Int64 {
+			return id.Int(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("cannot find the ID of element of type %T, the id field is required", element)
+}
+
+// esTextFields are analyzed for full-text search, other strings are
+// keywords.
+var esTextFields = []string{"name", "display_name", "real_name", "title", "profile", "contact_info", "notes", "description"}
+
+// esDateFields are ISO 8601 dates.
+var esDateFields = []string{"released_date"}
+
+// esNestedFields are indexed as nested documents, so that the fields of a
+// credit can be queried together.
+var esNestedFields = []string{"artists", "extra_artists", "labels"}
+
+// esSuggestFields are the top-level fields searched as you type, with a
+// completion sub-field.
+var esSuggestFields = []string{"name", "title"}
+
+// esMapping returns the index mapping of the documents of an entity, as
+// written by the es-bulk format.
+func esMapping(entity any) map[string]any {
+	return map[string]any{
+		"mappings": map[string]any{
+			"dynamic":    false,
+			"properties": esProperties(reflect.TypeOf(entity), true),
+		},
+	}
+}
+
+func esProperties(t reflect.Type, root bool) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	properties := make(map[string]any)
+	for _, field := range reflect.VisibleFields(t) {
+		if field.Anonymous || !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+
+		property := esProperty(field.Type, name)
+		if root && slices.Contains(esSuggestFields, name) {
+			property["fields"].(map[string]any)["suggest"] = map[string]any{"type": "completion"}
+		}
+		properties[name] = property
+	}
+
+	return properties
+}
+
+func esProperty(t reflect.Type, name string) map[string]any {
+	for t.Kind() == reflect.Pointer || (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if slices.Contains(esDateFields, name) {
+			return map[string]any{"type": "date", "format": "strict_date"}
+		}
+		if slices.Contains(esTextFields, name) {
+			return map[string]any{
+				"type": "text",
+				"fields": map[string]any{
+					"keyword": map[string]any{"type": "keyword", "ignore_above": 256},
+				},
+			}
+		}
+		return map[string]any{"type": "keyword"}
+	case reflect.Int64:
+		return map[string]any{"type": "long"}
+	case reflect.Int32, reflect.Int:
+		return map[string]any{"type": "integer"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Struct:
+		property := map[string]any{"properties": esProperties(t, false)}
+		if slices.Contains(esNestedFields, name) {
+			property["type"] = "nested"
+		}
+		return property
+	default:
+		return map[string]any{"type": "keyword"}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// ndjsonWriter writes one JSON document per line.
+type ndjsonWriter struct {
+	w       io.Writer
+	encoder *json.Encoder
+}
+
+func newNdjsonWriter(w io.Writer) *ndjsonWriter {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	return &ndjsonWriter{w: w, encoder: encoder}
+}
+
+func (w *ndjsonWriter) Write(element any) error {
+	if prepared, ok := element.(*preparedElement); ok {
+		if _, err := w.w.Write(prepared.JSON); err != nil {
+			return err
+		}
+		_, err := w.w.Write([]byte("\n"))
+		return err
+	}
+
+	return w.encoder.Encode(element)
+}
+
+func (w *ndjsonWriter) Close() error {
+	return nil
+}
+
+// jsonArrayWriter writes all the documents in a single JSON array.
+type jsonArrayWriter struct {
+	w       io.Writer
+	buf     bytes.Buffer
+	encoder *json.Encoder
+	pretty  bool
+	n       int64
+}
+
+func newJSONArrayWriter(w io.Writer, pretty bool) *jsonArrayWriter {
+	jw := &jsonArrayWriter{w: w, pretty: pretty}
+	jw.encoder = json.NewEncoder(&jw.buf)
+	jw.encoder.SetEscapeHTML(false)
+	if pretty {
+		jw.encoder.SetIndent("  ", "  ")
+	}
+
+	return jw
+}
+
+func (w *jsonArrayWriter) Write(element any) error {
+	w.buf.Reset()
+	if w.n == 0 {
+		w.buf.WriteString("[")
+	} else {
+		w.buf.WriteString(",")
+	}
+	if w.pretty {
+		w.buf.WriteString("\n  ")
+	}
+
+	if prepared, ok := element.(*preparedElement); ok {
+		if w.pretty {
+			if err := json.Indent(&w.buf, prepared.JSON, "  ", "  "); err != nil {
+				return err
+			}
+		} else {
+			w.buf.Write(prepared.JSON)
+		}
+	} else {
+		if err := w.encoder.Encode(element); err != nil {
+			return err
+		}
+		// The encoder terminates each document with a newline.
+		w.buf.Truncate(w.buf.Len() - 1)
+	}
+	w.n++
+
+	_, err := w.w.Write(w.buf.Bytes())
+	return err
+}
+
+func (w *jsonArrayWriter) Close() error {
+	closing := "]\n"
+	if w.n == 0 {
+		closing = "[]\n"
+	} else if w.pretty {
+		closing = "\n]\n"
+	}
+
+	_, err := io.WriteString(w.w, closing)
+	return err
+}
@@ -0,0 +1,76 @@
+//go:build go1.27 && goexperiment.jsonv2
+
+// Go 1.27 enables the experiment by default and only exposes encoding/json/v2
+// to files of Go 1.27 or later. The go1.27 constraint upgrades this file while
+// go.mod targets an earlier version.
+
+package main
+
+import (
+	"encoding/json/jsontext"
+	"encoding/json/v2"
+	"io"
+)
+
+// jsonv2Writer streams documents with encoding/json/v2, which marshals
+// straight into the output buffer instead of allocating every document.
+type jsonv2Writer struct {
+	encoder *jsontext.Encoder
+	options json.Options
+	array   bool
+	started bool
+}
+
+// jsonv2Options keep the output close to encoding/json: nil slices and maps
+// are null and HTML characters aren't escaped.
+var jsonv2Options = json.JoinOptions(
+	json.FormatNilSliceAsNull(true),
+	json.FormatNilMapAsNull(true),
+	jsontext.EscapeForHTML(false),
+)
+
+func newJSONv2Writer(w io.Writer, array bool, pretty bool) (elementWriter, error) {
+	options := json.JoinOptions(jsonv2Options, jsontext.Multiline(pretty))
+	// WithIndent enables multi-line output on its own.
+	if pretty {
+		options = json.JoinOptions(options, jsontext.WithIndent("  "))
+	}
+
+	return &jsonv2Writer{
+		encoder: jsontext.NewEncoder(w, options),
+		options: options,
+		array:   array,
+	}, nil
+}
+
+func (w *jsonv2Writer) Write(element any) error {
+	if w.array && !w.started {
+		if err := w.encoder.WriteToken(jsontext.BeginArray); err != nil {
+			return err
+		}
+	}
+	w.started = true
+
+	if prepared, ok := element.(*preparedElement); ok {
+		return w.encoder.WriteValue(jsontext.Value(prepared.JSON))
+	}
+
+	return json.MarshalEncode(w.encoder, element, w.options)
+}
+
+func (w *jsonv2Writer) Close() error {
+	if !w.array {
+		return nil
+	}
+	if !w.started {
+		if err := w.encoder.WriteToken(jsontext.BeginArray); err != nil {
+			return err
+		}
+	}
+
+	return w.encoder.WriteToken(jsontext.EndArray)
+}
+
+func marshalJSONv2(element any) ([]byte, error) {
+	return json.Marshal(element, jsonv2Options)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/urfave/cli/v3"
+)
+
+// conversionManifest describes the conversion of the dumps of a month.
+type conversionManifest struct {
+	Month           string                `json:"month"`
+	Format          string                `json:"format"`
+	Version         string                `json:"dgtools_version"`
+	StartedAt       time.Time             `json:"started_at"`
+	DurationSeconds float64               `json:"duration_seconds"`
+	Dumps           []manifestDumpSummary `json:"dumps"`
+}
+
+type manifestDumpSummary struct {
+	Type            string    `json:"type"`
+	Source          string    `json:"source"`
+	SourceSHA256    string    `json:"source_sha256,omitempty"`
+	Output          string    `json:"output"`
+	Mentions        string    `json:"mentions,omitempty"`
+	Rows            int64     `json:"rows"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// Unparsed reports the values --normalize couldn't parse.
+	Unparsed []discogs.UnparsedValues `json:"unparsed,omitempty"`
+}
+
+// findMonthDumps returns the dump of every type of the given year and month
+// found in dir, keyed by type.
+func findMonthDumps(dir string, year string, month string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]string)
+	for _, entry := range entries {
+		name := discogs.DumpFilename(entry.Name())
+		if entry.IsDir() || !strings.HasSuffix(name.String(), ".xml.gz") || !name.Valid() {
+			continue
+		}
+		if name.Year() != year || name.Month() != month {
+			continue
+		}
+		if previous, exists := found[name.Type()]; exists {
+			return nil, fmt.Errorf("found several %s dumps for %s-%s: %s and %s", name.Type(), year, month, previous, name)
+		}
+		found[name.Type()] = filepath.Join(dir, name.String())
+	}
+
+	missing := make([]string, 0)
+	for _, dumpType := range discogs.DumpTypes {
+		if _, exists := found[dumpType]; !exists {
+			missing = append(missing, dumpType)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no %s dump for %s-%s in %s", strings.Join(missing, ", "), year, month, dir)
+	}
+
+	return found, nil
+}
+
+// monthProgress shows the progress of concurrent conversions on a single
+// spinner.
+type monthProgress struct {
+	spinner *spinner.Spinner
+	mu      sync.Mutex
+	rows    map[string]int64
+	done    map[string]bool
+}
+
+func (p *monthProgress) update(dumpType string, rows int64, done bool) {
+	if p.spinner == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rows[dumpType] = rows
+	p.done[dumpType] = done
+
+	parts := make([]string, 0, len(discogs.DumpTypes))
+	for _, t := range discogs.DumpTypes {
+		part := fmt.Sprintf("%s %d", t, p.rows[t])
+		if p.done[t] {
+			part += " ✓"
+		}
+		parts = append(parts, part)
+	}
+
+	p.spinner.Lock()
+	p.spinner.Suffix = " Converting... " + strings.Join(parts, " | ")
+	p.spinner.Unlock()
+}
+
+// convertMonth converts the four dumps of a month concurrently into
+// --out-dir and writes a manifest of the conversion next to them.
+func convertMonth(ctx context.Context, cmd *cli.Command, month string, options outputOptions) error {
+	outDir := cmd.String("out-dir")
+	if outDir == "" {
+		return fmt.Errorf("--out-dir is required to convert the dumps of a month")
+	}
+	year, monthNumber, _ := strings.Cut(month, "-")
+
+	dumps, err := findMonthDumps(cmd.String("in-dir"), year, monthNumber)
+	if err != nil {
+		return err
+	}
+
+	storage, outDir, err := newOutputStorage(cmd, outDir)
+	if err != nil {
+		return err
+	}
+	if err := storage.MkdirAll(outDir); err != nil {
+		return err
+	}
+	options.Storage = storage
+
+	progress := &monthProgress{rows: make(map[string]int64), done: make(map[string]bool)}
+	if !cmd.Bool("no-progress") {
+		progress.spinner = spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+		progress.spinner.Suffix = " Converting..."
+		progress.spinner.Start()
+	}
+
+	manifest := conversionManifest{
+		Month:     month,
+		Format:    options.Format,
+		Version:   VERSION,
+		StartedAt: time.Now(),
+		Dumps:     make([]manifestDumpSummary, len(discogs.DumpTypes)),
+	}
+
+	// The first error cancels the other conversions.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	flags := commandFlags(cmd)
+	for i, dumpType := range discogs.DumpTypes {
+		source := dumps[dumpType]
+
+		// Each type has its own projection.
+		o := options
+		if options.Fields != nil {
+			o.Fields = newFieldSelection(options.Fields.Include, options.Fields.Exclude)
+		}
+		base := strings.TrimSuffix(filepath.Base(source), ".xml.gz")
+		o.Out = filepath.Join(outDir, base)
+		if !o.directory() {
+			o.Out += o.extension()
+		}
+		o.Provenance = newSourceProvenance(source, flags)
+		if options.Normalizer != nil {
+			o.Normalizer = discogs.NewNormalizer()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			summary, err := convertMonthDump(ctx, cmd, dumpType, source, o, progress)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("%s: %w", filepath.Base(source), err)
+					cancel()
+				})
+				return
+			}
+			manifest.Dumps[i] = summary
+		}()
+	}
+	wg.Wait()
+
+	if progress.spinner != nil {
+		progress.spinner.Stop()
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	manifest.DurationSeconds = time.Since(manifest.StartedAt).Seconds()
+
+	manifestFile := filepath.Join(outDir, fmt.Sprintf("discogs_%s%s_manifest.json", year, monthNumber))
+	file, err := storage.Create(ctx, manifestFile)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONFile(file, manifest); err != nil {
+		return err
+	}
+
+	if progress.spinner != nil {
+		for _, summary := range manifest.Dumps {
+			fmt.Printf("Converted %d %s in %s.\n", summary.Rows, summary.Type, time.Duration(summary.DurationSeconds*float64(time.Second)))
+		}
+		fmt.Printf("Manifest written to %s.\n", cmd.String("out-dir"))
+	}
+	for _, summary := range manifest.Dumps {
+		reportUnparsed(summary.Unparsed)
+	}
+
+	return nil
+}
+
+// convertMonthDump converts one of the dumps of a month.
+func convertMonthDump(ctx context.Context, cmd *cli.Command, dumpType string, source string, o outputOptions, progress *monthProgress) (manifestDumpSummary, error) {
+	summary := manifestDumpSummary{
+		Type:      dumpType,
+		Source:    filepath.Base(source),
+		Output:    filepath.Base(o.Out),
+		StartedAt: time.Now(),
+	}
+
+	rows, err := convertDump(ctx, source, o, cmd.Int("workers"), cmd.Int64("stop-after"), func(rows int64) {
+		progress.update(dumpType, rows, false)
+	})
+	if err != nil {
+		return summary, err
+	}
+	p, err := o.Provenance.Provenance()
+	if err != nil {
+		return summary, err
+	}
+	summary.SourceSHA256 = p.SourceSHA256
+	if o.Mentions {
+		summary.Mentions = filepath.Base(o.mentionsFile())
+	}
+	if o.Normalizer != nil {
+		summary.Unparsed = o.Normalizer.Unparsed()
+	}
+
+	summary.Rows = rows
+	summary.DurationSeconds = time.Since(summary.StartedAt).Seconds()
+	progress.update(dumpType, rows, true)
+	recordRun(cmd, source, catalogRun{At: summary.StartedAt, Format: o.Format, Output: o.Out, Rows: rows, Flags: commandFlags(cmd)})
+
+	return summary, nil
+}
@@ -0,0 +1,18 @@
+//go:build !go1.27 || !goexperiment.jsonv2
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+var errJSONv2Unavailable = errors.New("--json-v2 requires dgtools to be built with Go 1.27 or later and GOEXPERIMENT=jsonv2")
+
+func newJSONv2Writer(w io.Writer, array bool, pretty bool) (elementWriter, error) {
+	return nil, errJSONv2Unavailable
+}
+
+func marshalJSONv2(element any) ([]byte, error) {
+	return nil, errJSONv2Unavailable
+}
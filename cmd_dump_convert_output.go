@@ -0,0 +1,649 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/marcw/dgtools/internal/discogs"
+)
+
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+var outputCompressions = []string{CompressGzip, CompressZstd}
+
+const (
+	PartitionByYear    = "year"
+	PartitionByCountry = "country"
+	PartitionByGenre   = "genre"
+)
+
+var partitionKeys = []string{PartitionByYear, PartitionByCountry, PartitionByGenre}
+
+// defaultPartition is the partition value used by Hive when the partition
+// column is NULL.
+const defaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// outputOptions describes where and how converted elements are written.
+type outputOptions struct {
+	Format          string
+	Out             string
+	PartitionBy     string
+	MaxRowsPerFile  int64
+	MaxBytesPerFile int64
+	// MaxOpenPartitions is the maximum number of partitions written to at
+	// once, as each keeps a file and a parquet row group open.
+	MaxOpenPartitions int
+	Fields            *fieldSelection
+	Compress          string
+	Pretty            bool
+	JSONv2            bool
+	ESIndex           string
+	Parquet           parquetOptions
+	// Storage is where Out is created, the local filesystem when nil.
+	Storage outputStorage
+	// Provenance is embedded in parquet files, and written to a sidecar
+	// next to other files.
+	Provenance *sourceProvenance
+	// Normalizer fills the parsed fields of the elements when not nil.
+	Normalizer *discogs.Normalizer
+	// Markup is the format the markup of profiles and notes is rendered
+	// to, kept as is when empty.
+	Markup string
+	// Mentions writes the entities referenced by the markup to
+	// mentionsFile.
+	Mentions bool
+}
+
+func (o outputOptions) storage() outputStorage {
+	if o.Storage == nil {
+		return localStorage{}
+	}
+
+	return o.Storage
+}
+
+// directory returns true when the output is a directory of part files
+// rather than a single file.
+func (o outputOptions) directory() bool {
+	return o.PartitionBy != "" || o.MaxRowsPerFile > 0 || o.MaxBytesPerFile > 0
+}
+
+// skippedElements returns the XML elements of the entities that are
+// neither projected nor partitioned on, and don't need to be decoded.
+func (o outputOptions) skippedElements(element any) ([]string, error) {
+	if o.Fields == nil {
+		return nil, nil
+	}
+
+	projection, err := o.Fields.Projection(element)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(slices.Clone(projection.SkippedElements()), func(name string) bool {
+		return slices.Contains(partitionElements(o.PartitionBy), name) || o.preparedFrom(element, projection, name)
+	}), nil
+}
+
+// preparedFrom returns true if prepareElement needs the given XML element of
+// an entity to extract its mentions or to fill a projected field.
+func (o outputOptions) preparedFrom(element any, projection *discogs.Projection, name string) bool {
+	if o.Mentions && name == discogs.MarkupElement(element) {
+		return true
+	}
+
+	return o.Normalizer != nil && discogs.NormalizedFrom(projection.Type(), name)
+}
+
+// prepareElement normalizes a decoded element and renders its markup. It
+// returns the entities mentioned by the markup when they are written.
+func (o outputOptions) prepareElement(element any) ([]discogs.Mention, error) {
+	if o.Normalizer != nil {
+		o.Normalizer.Normalize(element)
+	}
+
+	// Mentions are extracted before the markup is rendered.
+	var mentions []discogs.Mention
+	if o.Mentions {
+		mentions = discogs.Mentions(element)
+	}
+	if o.Markup != "" {
+		if err := discogs.RenderMarkup(element, o.Markup); err != nil {
+			return nil, err
+		}
+	}
+
+	return mentions, nil
+}
+
+// mentionsFile returns the file mentions are written to, next to Out, such
+// as releases_mentions.parquet for releases.parquet.
+func (o outputOptions) mentionsFile() string {
+	name := strings.TrimSuffix(o.Out, "/")
+	if !o.directory() {
+		name = strings.TrimSuffix(name, o.extension())
+	}
+
+	return name + "_mentions" + o.extension()
+}
+
+// mentionsOptions returns the options of the output of mentions, a single
+// file in the output format.
+func (o outputOptions) mentionsOptions() outputOptions {
+	m := o
+	m.Out = o.mentionsFile()
+	m.PartitionBy = ""
+	m.MaxRowsPerFile = 0
+	m.MaxBytesPerFile = 0
+	m.Fields = nil
+	m.Normalizer = nil
+	m.Markup = ""
+	m.Mentions = false
+	m.Parquet.Row = &discogs.Mention{}
+
+	return m
+}
+
+func (o outputOptions) extension() string {
+	ext := "." + o.Format
+	switch o.Format {
+	case FormatProtobuf:
+		ext = ".pb"
+	case FormatESBulk:
+		ext = ".bulk.ndjson"
+	case FormatNTriples:
+		ext = ".nt"
+	}
+
+	switch o.Compress {
+	case CompressGzip:
+		return ext + ".gz"
+	case CompressZstd:
+		return ext + ".zst"
+	default:
+		return ext
+	}
+}
+
+// newOutputWriter returns the elementWriter matching the options. Closing it
+// closes every file it created, cancelling ctx beforehand aborts them.
+func newOutputWriter(ctx context.Context, o outputOptions) (elementWriter, error) {
+	if !o.directory() {
+		if o.Out == "" {
+			return newFileWriter(ctx, os.Stdout, "", o)
+		}
+
+		file, err := o.storage().Create(ctx, o.Out)
+		if err != nil {
+			return nil, err
+		}
+		return newFileWriter(ctx, file, o.Out, o)
+	}
+
+	if o.Out == "" {
+		return nil, fmt.Errorf("output directory is required for partitioned or rolled output")
+	}
+	if err := o.storage().MkdirAll(o.Out); err != nil {
+		return nil, err
+	}
+
+	if o.PartitionBy != "" {
+		return newPartitionedWriter(ctx, o), nil
+	}
+
+	return newRollingWriter(ctx, o.Out, o), nil
+}
+
+// newFormatWriter returns an elementWriter encoding elements to w in the
+// output format.
+func newFormatWriter(w io.Writer, o outputOptions) (elementWriter, error) {
+	if o.JSONv2 && (o.Format == FormatNdjson || o.Format == FormatJSON) {
+		return newJSONv2Writer(w, o.Format == FormatJSON, o.Pretty)
+	}
+
+	switch o.Format {
+	case FormatParquet:
+		return newParquetWriter(w, o.Parquet)
+	case FormatNdjson:
+		return newNdjsonWriter(w), nil
+	case FormatJSON:
+		return newJSONArrayWriter(w, o.Pretty), nil
+	case FormatProtobuf:
+		return newProtobufWriter(w), nil
+	case FormatESBulk:
+		return newESBulkWriter(w, o.ESIndex), nil
+	case FormatNTriples:
+		return newNTriplesWriter(w), nil
+	case FormatJSONLD:
+		return newJSONLDWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", o.Format)
+	}
+}
+
+// fieldSelection projects elements on the fields selected with --fields and
+// --exclude-fields. The projection is built from the first element, as all
+// the elements of a dump have the same type.
+type fieldSelection struct {
+	Include    []string
+	Exclude    []string
+	mu         sync.Mutex
+	projection *discogs.Projection
+}
+
+// newFieldSelection returns nil when no field is selected nor excluded.
+func newFieldSelection(include []string, exclude []string) *fieldSelection {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+
+	return &fieldSelection{Include: include, Exclude: exclude}
+}
+
+func (f *fieldSelection) Projection(element any) (*discogs.Projection, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.projection == nil {
+		projection, err := discogs.NewProjection(element, f.Include, f.Exclude)
+		if err != nil {
+			return nil, err
+		}
+		f.projection = projection
+	}
+
+	return f.projection, nil
+}
+
+func (f *fieldSelection) Project(element any) (any, error) {
+	projection, err := f.Projection(element)
+	if err != nil {
+		return nil, err
+	}
+
+	return projection.Project(element)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// newCompressor returns a writer compressing its input to w, or nil if the
+// output is not compressed.
+func newCompressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "":
+		return nil, nil
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	case CompressZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("supported output compressions are: %s", strings.Join(outputCompressions, ", "))
+	}
+}
+
+// fileWriter encodes elements into a single file, optionally compressed.
+// name is empty for the standard output.
+type fileWriter struct {
+	ctx        context.Context
+	name       string
+	file       io.WriteCloser
+	counter    *countingWriter
+	compressor io.WriteCloser
+	writer     elementWriter
+	fields     *fieldSelection
+	storage    outputStorage
+	provenance *sourceProvenance
+}
+
+func newFileWriter(ctx context.Context, file io.WriteCloser, name string, o outputOptions) (*fileWriter, error) {
+	fw := &fileWriter{
+		ctx:        ctx,
+		name:       name,
+		file:       file,
+		fields:     o.Fields,
+		storage:    o.storage(),
+		provenance: o.Provenance,
+	}
+	fw.counter = &countingWriter{w: file}
+
+	var w io.Writer = fw.counter
+	compressor, err := newCompressor(fw.counter, o.Compress)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if compressor != nil {
+		fw.compressor = compressor
+		w = compressor
+	}
+
+	fw.writer, err = newFormatWriter(w, o)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return fw, nil
+}
+
+func (w *fileWriter) Write(element any) error {
+	// Prepared elements are already projected.
+	if _, prepared := element.(*preparedElement); !prepared && w.fields != nil {
+		projected, err := w.fields.Project(element)
+		if err != nil {
+			return err
+		}
+		element = projected
+	}
+
+	return w.writer.Write(element)
+}
+
+// Size returns the number of bytes written to the file so far. Formats
+// buffering their output, like parquet, and compressed output lag behind
+// what was written.
+func (w *fileWriter) Size() int64 {
+	return w.counter.n
+}
+
+func (w *fileWriter) Close() error {
+	// Aborted files don't get any provenance.
+	var p *provenance
+	hashed := false
+	if w.provenance != nil && w.ctx.Err() == nil {
+		var provenance provenance
+		provenance, hashed = w.provenance.current()
+		p = &provenance
+	}
+
+	setter, embedded := w.writer.(metadataSetter)
+	if p != nil && embedded {
+		kv, err := p.keyValues()
+		if err != nil {
+			w.file.Close()
+			return err
+		}
+		for key, value := range kv {
+			setter.SetMetadata(key, value)
+		}
+	}
+
+	if err := w.writer.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if w.compressor != nil {
+		if err := w.compressor.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+
+	if w.file == os.Stdout {
+		return nil
+	}
+	if file, ok := w.file.(*os.File); ok {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	// Files closed before the source was hashed, such as the first parts of
+	// rolled output, get the checksum in a sidecar once it's known.
+	if p != nil && (!embedded || !hashed) && w.name != "" {
+		return w.provenance.sidecar(func(p provenance) error {
+			return writeSidecar(w.ctx, w.storage, w.name, p)
+		})
+	}
+
+	return nil
+}
+
+// rollingWriter writes elements to part-N files in a directory, starting a
+// new file every time one reaches MaxRowsPerFile rows or MaxBytesPerFile
+// bytes.
+type rollingWriter struct {
+	ctx     context.Context
+	dir     string
+	options outputOptions
+	part    int
+	rows    int64
+	current *fileWriter
+}
+
+func newRollingWriter(ctx context.Context, dir string, o outputOptions) *rollingWriter {
+	return &rollingWriter{ctx: ctx, dir: dir, options: o}
+}
+
+func (w *rollingWriter) Write(element any) error {
+	// Files are opened lazily so that no empty part is left behind when the
+	// last file is rolled over.
+	if w.current == nil {
+		if err := w.options.storage().MkdirAll(w.dir); err != nil {
+			return err
+		}
+
+		name := filepath.Join(w.dir, fmt.Sprintf("part-%05d%s", w.part, w.options.extension()))
+		file, err := w.options.storage().Create(w.ctx, name)
+		if err != nil {
+			return err
+		}
+		w.current, err = newFileWriter(w.ctx, file, name, w.options)
+		if err != nil {
+			return err
+		}
+		w.part++
+		w.rows = 0
+	}
+
+	if err := w.current.Write(element); err != nil {
+		return err
+	}
+	w.rows++
+
+	if (w.options.MaxRowsPerFile > 0 && w.rows >= w.options.MaxRowsPerFile) ||
+		(w.options.MaxBytesPerFile > 0 && w.current.Size() >= w.options.MaxBytesPerFile) {
+		return w.rollover()
+	}
+
+	return nil
+}
+
+func (w *rollingWriter) rollover() error {
+	err := w.current.Close()
+	w.current = nil
+	return err
+}
+
+func (w *rollingWriter) Close() error {
+	if w.current == nil {
+		return nil
+	}
+
+	return w.rollover()
+}
+
+// partitionedWriter writes elements to a Hive-style key=value directory tree,
+// each partition being rolled independently. At most MaxOpenPartitions
+// partitions have a file open: writing to another one rolls the least
+// recently written over, its next rows going to a new part file.
+type partitionedWriter struct {
+	ctx        context.Context
+	options    outputOptions
+	partitions map[string]*rollingWriter
+	// used is when each partition was last written to, as a count of
+	// writes.
+	used   map[string]int64
+	writes int64
+}
+
+func newPartitionedWriter(ctx context.Context, o outputOptions) *partitionedWriter {
+	return &partitionedWriter{
+		ctx:        ctx,
+		options:    o,
+		partitions: make(map[string]*rollingWriter),
+		used:       make(map[string]int64),
+	}
+}
+
+func (w *partitionedWriter) Write(element any) error {
+	value, err := partitionValue(w.options.PartitionBy, unwrapElement(element))
+	if err != nil {
+		return err
+	}
+
+	partition, exists := w.partitions[value]
+	if !exists {
+		dir := filepath.Join(w.options.Out, fmt.Sprintf("%s=%s", w.options.PartitionBy, escapePartitionValue(value)))
+		partition = newRollingWriter(w.ctx, dir, w.options)
+		w.partitions[value] = partition
+	}
+	if partition.current == nil && w.options.MaxOpenPartitions > 0 {
+		if err := w.evict(w.options.MaxOpenPartitions - 1); err != nil {
+			return err
+		}
+	}
+	w.writes++
+	w.used[value] = w.writes
+
+	return partition.Write(element)
+}
+
+// evict rolls the least recently written partitions over until at most
+// open of them have a file open.
+func (w *partitionedWriter) evict(open int) error {
+	for {
+		oldest, count := "", 0
+		for value, partition := range w.partitions {
+			if partition.current == nil {
+				continue
+			}
+			count++
+			if oldest == "" || w.used[value] < w.used[oldest] {
+				oldest = value
+			}
+		}
+		if count <= open {
+			return nil
+		}
+		if err := w.partitions[oldest].rollover(); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *partitionedWriter) Close() error {
+	var firstErr error
+	for _, partition := range w.partitions {
+		if err := partition.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// partitionValue extracts the value of the partition key from an element.
+// An empty string means the element has no value for the key.
+//
+// Releases and masters with several genres are partitioned by their first
+// genre so that every row is written exactly once.
+func partitionValue(key string, element any) (string, error) {
+	switch e := element.(type) {
+	case *discogs.Release:
+		switch key {
+		case PartitionByYear:
+			if e.Released != nil && len(*e.Released) >= 4 && (*e.Released)[:4] != "0000" {
+				return (*e.Released)[:4], nil
+			}
+			return "", nil
+		case PartitionByCountry:
+			if e.Country != nil {
+				return *e.Country, nil
+			}
+			return "", nil
+		case PartitionByGenre:
+			if len(e.Genres) > 0 {
+				return e.Genres[0], nil
+			}
+			return "", nil
+		}
+	case *discogs.Master:
+		switch key {
+		case PartitionByYear:
+			// Masters without a year have a year of 0.
+			if e.Year != nil && *e.Year != 0 {
+				return fmt.Sprintf("%d", *e.Year), nil
+			}
+			return "", nil
+		case PartitionByGenre:
+			if len(e.Genres) > 0 {
+				return e.Genres[0], nil
+			}
+			return "", nil
+		}
+	}
+
+	return "", fmt.Errorf("cannot partition %T by %s", element, key)
+}
+
+// partitionElements returns the XML elements the value of the partition key
+// is decoded from.
+func partitionElements(key string) []string {
+	switch key {
+	case PartitionByYear:
+		return []string{"released", "year"}
+	case PartitionByCountry:
+		return []string{"country"}
+	case PartitionByGenre:
+		return []string{"genres"}
+	default:
+		return nil
+	}
+}
+
+// escapePartitionValue escapes a partition value so that it's a valid path
+// segment, the way Hive does: characters that aren't safe in a path are
+// percent-encoded.
+func escapePartitionValue(value string) string {
+	if value == "" {
+		return defaultPartition
+	}
+
+	var sb strings.Builder
+	for _, r := range value {
+		switch {
+		case r < 0x20, r == 0x7f, strings.ContainsRune(`"#%'*/:=?\{[]^`, r):
+			fmt.Fprintf(&sb, "%%%02X", r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+
+	return sb.String()
+}
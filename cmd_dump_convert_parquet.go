@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	kzstd "github.com/klauspost/compress/zstd"
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/compress/brotli"
+	"github.com/parquet-go/parquet-go/compress/gzip"
+	"github.com/parquet-go/parquet-go/compress/lz4"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+const (
+	CodecZstd         = "zstd"
+	CodecSnappy       = "snappy"
+	CodecGzip         = "gzip"
+	CodecBrotli       = "brotli"
+	CodecLz4          = "lz4"
+	CodecUncompressed = "none"
+)
+
+var parquetCodecs = []string{CodecZstd, CodecSnappy, CodecGzip, CodecBrotli, CodecLz4, CodecUncompressed}
+
+// compressionLevels are the ranges of the levels of the codecs which have
+// levels.
+var compressionLevels = map[string][2]int{
+	CodecZstd:   {1, 22},
+	CodecGzip:   {1, 9},
+	CodecBrotli: {0, 11},
+	CodecLz4:    {1, 9},
+}
+
+// validateCompressionLevel checks that level is a level of codec.
+func validateCompressionLevel(codec string, level int) error {
+	levels, exists := compressionLevels[codec]
+	if !exists {
+		return fmt.Errorf("the %s codec has no compression levels", codec)
+	}
+	if level < levels[0] || level > levels[1] {
+		return fmt.Errorf("the compression levels of %s range from %d to %d", codec, levels[0], levels[1])
+	}
+
+	return nil
+}
+
+// parquetBatchSize is the number of rows buffered before being handed to the
+// underlying parquet writer.
+const parquetBatchSize = 1024
+
+// parquetOptions holds the tuning knobs of the parquet writer.
+type parquetOptions struct {
+	Codec string
+	// Level is the compression level, the codec's default when nil.
+	Level        *int
+	RowGroupSize int64
+	PageSize     int
+	SortByID     bool
+	BloomFilters bool
+	// Row is an element of the type of the rows, when it's known in
+	// advance, so that a file without any row still has a schema.
+	Row any
+}
+
+// compressionCodec returns the parquet codec matching the options.
+func (o parquetOptions) compressionCodec() (compress.Codec, error) {
+	switch o.Codec {
+	case CodecZstd:
+		codec := &zstd.Codec{Level: zstd.DefaultLevel}
+		if o.Level != nil {
+			codec.Level = kzstd.EncoderLevelFromZstd(*o.Level)
+		}
+		return codec, nil
+	case CodecSnappy:
+		return &parquet.Snappy, nil
+	case CodecGzip:
+		codec := &gzip.Codec{Level: gzip.DefaultCompression}
+		if o.Level != nil {
+			codec.Level = *o.Level
+		}
+		return codec, nil
+	case CodecBrotli:
+		codec := &brotli.Codec{Quality: brotli.DefaultQuality, LGWin: brotli.DefaultLGWin}
+		if o.Level != nil {
+			codec.Quality = *o.Level
+		}
+		return codec, nil
+	case CodecLz4:
+		codec := &lz4.Codec{Level: lz4.DefaultLevel}
+		if o.Level != nil {
+			codec.Level = lz4.Level(1 << (8 + *o.Level))
+		}
+		return codec, nil
+	case CodecUncompressed:
+		return &parquet.Uncompressed, nil
+	default:
+		return nil, fmt.Errorf("supported compression codecs are: %s", strings.Join(parquetCodecs, ", "))
+	}
+}
+
+// bloomFilterColumns are the ID columns worth indexing with a bloom filter,
+// when they exist in the schema.
+var bloomFilterColumns = []string{"id", "master_id", "main_release_id", "parent_label_id"}
+
+func (o parquetOptions) writerOptions(schema *parquet.Schema) ([]parquet.WriterOption, error) {
+	codec, err := o.compressionCodec()
+	if err != nil {
+		return nil, err
+	}
+
+	options := []parquet.WriterOption{
+		schema,
+		parquet.Compression(codec),
+		parquet.CreatedBy("dgtools", VERSION, ""),
+		// Page statistics are duplicated from the page index for readers
+		// that don't load column indexes.
+		parquet.DataPageStatistics(true),
+	}
+	if o.RowGroupSize > 0 {
+		options = append(options, parquet.MaxRowsPerRowGroup(o.RowGroupSize))
+	}
+	if o.PageSize > 0 {
+		options = append(options, parquet.PageBufferSize(o.PageSize))
+	}
+	// Columns may have been left out by a projection.
+	if _, exists := schema.Lookup("id"); o.SortByID && exists {
+		options = append(options, parquet.SortingWriterConfig(
+			parquet.SortingColumns(parquet.Ascending("id")),
+		))
+	}
+	if o.BloomFilters {
+		filters := make([]parquet.BloomFilterColumn, 0)
+		for _, column := range bloomFilterColumns {
+			if _, exists := schema.Lookup(column); exists {
+				filters = append(filters, parquet.SplitBlockFilter(10, column))
+			}
+		}
+		options = append(options, parquet.BloomFilters(filters...))
+	}
+
+	return options, nil
+}
+
+// parquetWriter writes decoded dump elements to a parquet file.
+// The schema is derived from the type of the first element written.
+type parquetWriter struct {
+	output   io.Writer
+	options  parquetOptions
+	writer   elementWriter
+	metadata map[string]string
+}
+
+type elementWriter interface {
+	Write(element any) error
+	Close() error
+}
+
+func newParquetWriter(output io.Writer, options parquetOptions) (*parquetWriter, error) {
+	// Validate the codec before the first element comes in.
+	if _, err := options.compressionCodec(); err != nil {
+		return nil, err
+	}
+
+	return &parquetWriter{output: output, options: options, metadata: make(map[string]string)}, nil
+}
+
+// SetMetadata sets a key/value pair of the metadata of the file, written in
+// its footer on Close.
+func (w *parquetWriter) SetMetadata(key, value string) {
+	w.metadata[key] = value
+}
+
+func (w *parquetWriter) Write(element any) error {
+	if w.writer == nil {
+		writer, err := w.newElementWriter(element)
+		if err != nil {
+			return err
+		}
+		w.writer = writer
+	}
+
+	return w.writer.Write(element)
+}
+
+func (w *parquetWriter) newElementWriter(element any) (elementWriter, error) {
+	if prepared, ok := element.(*preparedElement); ok {
+		element = prepared.Projected
+	}
+
+	switch element.(type) {
+	case *discogs.Artist:
+		return newTypedParquetWriter(w.output, w.options, element, derefRow[discogs.Artist])
+	case *discogs.Label:
+		return newTypedParquetWriter(w.output, w.options, element, derefRow[discogs.Label])
+	case *discogs.Master:
+		return newTypedParquetWriter(w.output, w.options, element, derefRow[discogs.Master])
+	case *discogs.Release:
+		return newTypedParquetWriter(w.output, w.options, element, derefRow[discogs.Release])
+	}
+
+	// Projected elements are pointers to structs built at runtime.
+	if t := reflect.TypeOf(element); t.Kind() == reflect.Pointer && t.Elem().Kind() == reflect.Struct {
+		return newTypedParquetWriter(w.output, w.options, element, func(element any) (any, error) {
+			if reflect.TypeOf(element) != t {
+				return nil, fmt.Errorf("cannot mix %T with rows of a different type", element)
+			}
+			return element, nil
+		})
+	}
+
+	return nil, fmt.Errorf("cannot write element of type %T to parquet", element)
+}
+
+// derefRow returns the row an element of type *T points to.
+func derefRow[T any](element any) (T, error) {
+	row, ok := element.(*T)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("cannot mix %T with rows of a different type", element)
+	}
+
+	return *row, nil
+}
+
+// Close flushes the buffered rows and writes the parquet footer.
+func (w *parquetWriter) Close() error {
+	if w.writer == nil && w.options.Row != nil {
+		writer, err := w.newElementWriter(w.options.Row)
+		if err != nil {
+			return err
+		}
+		w.writer = writer
+	}
+	if w.writer == nil {
+		return nil
+	}
+	if setter, ok := w.writer.(metadataSetter); ok {
+		for key, value := range w.metadata {
+			setter.SetMetadata(key, value)
+		}
+	}
+
+	return w.writer.Close()
+}
+
+type genericParquetWriter[T any] interface {
+	Write(rows []T) (int, error)
+	WriteRows(rows []parquet.Row) (int, error)
+	SetKeyValueMetadata(key, value string)
+	Flush() error
+	Close() error
+}
+
+// typedParquetWriter batches rows of a single entity type. Prepared elements
+// come already deconstructed and are batched separately.
+//
+// When rows are sorted, the sorting writer merges everything it buffered into
+// a single row group on Flush, so row groups are cut manually every
+// RowGroupSize rows.
+type typedParquetWriter[T any] struct {
+	writer       genericParquetWriter[T]
+	row          func(element any) (T, error)
+	rows         []T
+	prepared     []parquet.Row
+	sorted       bool
+	rowGroupSize int64
+	rowGroupRows int64
+}
+
+func newTypedParquetWriter[T any](output io.Writer, o parquetOptions, element any, row func(element any) (T, error)) (*typedParquetWriter[T], error) {
+	options, err := o.writerOptions(parquet.SchemaOf(element))
+	if err != nil {
+		return nil, err
+	}
+
+	w := &typedParquetWriter[T]{
+		row:          row,
+		rows:         make([]T, 0, parquetBatchSize),
+		sorted:       o.SortByID,
+		rowGroupSize: o.RowGroupSize,
+	}
+
+	if o.SortByID {
+		w.writer = parquet.NewSortingWriter[T](output, parquetBatchSize*64, options...)
+	} else {
+		w.writer = parquet.NewGenericWriter[T](output, options...)
+	}
+
+	return w, nil
+}
+
+func (w *typedParquetWriter[T]) Write(element any) error {
+	if prepared, ok := element.(*preparedElement); ok {
+		w.prepared = append(w.prepared, prepared.Row)
+		if len(w.prepared) >= parquetBatchSize {
+			return w.writeRows()
+		}
+		return nil
+	}
+
+	row, err := w.row(element)
+	if err != nil {
+		return err
+	}
+
+	w.rows = append(w.rows, row)
+	if len(w.rows) >= parquetBatchSize {
+		return w.writeRows()
+	}
+
+	return nil
+}
+
+func (w *typedParquetWriter[T]) writeRows() error {
+	if len(w.rows) > 0 {
+		if _, err := w.writer.Write(w.rows); err != nil {
+			return err
+		}
+		w.rowGroupRows += int64(len(w.rows))
+		clear(w.rows)
+		w.rows = w.rows[:0]
+	}
+	if len(w.prepared) > 0 {
+		if _, err := w.writer.WriteRows(w.prepared); err != nil {
+			return err
+		}
+		w.rowGroupRows += int64(len(w.prepared))
+		clear(w.prepared)
+		w.prepared = w.prepared[:0]
+	}
+
+	if w.sorted && w.rowGroupSize > 0 && w.rowGroupRows >= w.rowGroupSize {
+		w.rowGroupRows = 0
+		return w.writer.Flush()
+	}
+
+	return nil
+}
+
+func (w *typedParquetWriter[T]) SetMetadata(key, value string) {
+	w.writer.SetKeyValueMetadata(key, value)
+}
+
+func (w *typedParquetWriter[T]) Close() error {
+	if len(w.rows) > 0 || len(w.prepared) > 0 {
+		if err := w.writeRows(); err != nil {
+			return err
+		}
+	}
+
+	return w.writer.Close()
+}
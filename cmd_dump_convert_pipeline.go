@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/parquet-go/parquet-go"
+)
+
+// preparedElement is an element decoded by a conversion worker, along with
+// its encoding in the output format, computed in parallel.
+type preparedElement struct {
+	// Element is the decoded element, used to partition the output.
+	Element any
+	// Projected is the element after field projection, or Element itself.
+	Projected any
+	// JSON is the marshaled element for the ndjson, json and es-bulk formats.
+	JSON []byte
+	// Row is the deconstructed element for the parquet format.
+	Row parquet.Row
+	// Proto is the length-delimited message for the protobuf format.
+	Proto []byte
+	// Mentions are the entities mentioned by the markup of the element.
+	Mentions []discogs.Mention
+}
+
+// unwrapElement returns the decoded element of a preparedElement, or the
+// element itself.
+func unwrapElement(element any) any {
+	if prepared, ok := element.(*preparedElement); ok {
+		return prepared.Element
+	}
+
+	return element
+}
+
+// conversionJob is an entity read from the dump, waiting to be prepared by
+// a worker.
+type conversionJob struct {
+	raw    []byte
+	result chan conversionResult
+}
+
+type conversionResult struct {
+	element *preparedElement
+	err     error
+}
+
+// conversionPipeline converts a dump with one goroutine splitting the raw
+// entities, several workers decoding and encoding them, and the caller
+// writing them in the order of the dump.
+type conversionPipeline struct {
+	dump    *discogs.Dump
+	options outputOptions
+	workers int
+
+	// skip holds the elements workers don't need to decode, once known.
+	skip     atomic.Pointer[[]string]
+	skipOnce sync.Once
+}
+
+func newConversionPipeline(dump *discogs.Dump, options outputOptions, workers int) *conversionPipeline {
+	return &conversionPipeline{dump: dump, options: options, workers: workers}
+}
+
+// Run calls write with every prepared element, in order, until write returns
+// false, an error occurs or the dump is exhausted.
+func (p *conversionPipeline) Run(ctx context.Context, write func(element *preparedElement) (bool, error)) error {
+	// Goroutines are waited for after being cancelled.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Jobs are queued in dump order for the writer at the same time they are
+	// handed to the workers, the writer waits for each result in turn.
+	jobs := make(chan *conversionJob, p.workers*4)
+	queue := make(chan *conversionJob, p.workers*4)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(queue)
+
+		splitter := p.dump.Splitter()
+		for {
+			raw, err := splitter.Next()
+			if err == io.EOF {
+				return
+			}
+
+			job := &conversionJob{raw: raw, result: make(chan conversionResult, 1)}
+			if err != nil {
+				job.result <- conversionResult{err: err}
+			}
+
+			select {
+			case queue <- job:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for range p.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				element, err := p.prepare(job.raw)
+				job.result <- conversionResult{element: element, err: err}
+			}
+		}()
+	}
+
+	for job := range queue {
+		result := <-job.result
+		if result.err != nil {
+			return result.err
+		}
+		if result.element == nil {
+			continue
+		}
+
+		more, err := write(result.element)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// prepare decodes a raw entity, projects it and encodes it in the output
+// format. It returns nil if the raw XML is not an entity.
+func (p *conversionPipeline) prepare(raw []byte) (*preparedElement, error) {
+	var skip []string
+	if s := p.skip.Load(); s != nil {
+		skip = *s
+	}
+
+	element, err := discogs.DecodeRawElement(raw, skip)
+	if err != nil || element == nil {
+		return nil, err
+	}
+	mentions, err := p.options.prepareElement(element)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.options.Fields != nil {
+		p.skipOnce.Do(func() {
+			skip, err := p.options.skippedElements(element)
+			if err == nil {
+				p.skip.Store(&skip)
+			}
+		})
+	}
+
+	prepared := &preparedElement{Element: element, Projected: element, Mentions: mentions}
+	if p.options.Fields != nil {
+		prepared.Projected, err = p.options.Fields.Project(element)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch p.options.Format {
+	case FormatNdjson, FormatJSON, FormatESBulk:
+		prepared.JSON, err = marshalJSON(prepared.Projected, p.options.JSONv2)
+		if err != nil {
+			return nil, err
+		}
+	case FormatParquet:
+		prepared.Row = parquet.SchemaOf(prepared.Projected).Deconstruct(nil, prepared.Projected)
+	case FormatProtobuf:
+		prepared.Proto, err = appendDelimitedProto(nil, prepared.Projected)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return prepared, nil
+}
+
+// marshalJSON marshals an element the way the json writers do.
+func marshalJSON(element any, v2 bool) ([]byte, error) {
+	if v2 {
+		return marshalJSONv2(element)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(element); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/marcw/dgtools/internal/discogs/markup"
+)
+
+const releasesSample = "internal/discogs/testdata/discogs_20250101_releases.xml"
+
+// convertSample converts the releases sample with the given workers, and
+// returns the output.
+func convertSample(tb testing.TB, options outputOptions, workers int) []byte {
+	tb.Helper()
+
+	options.Out = filepath.Join(tb.TempDir(), "releases."+options.Format)
+	rows, err := convertDump(context.Background(), releasesSample, options, workers, 0, func(int64) {})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if rows == 0 {
+		tb.Fatal("no release converted")
+	}
+	out, err := os.ReadFile(options.Out)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return out
+}
+
+// The pipeline writes the same output as the sequential conversion, in the
+// order of the dump.
+func TestConvertWorkersOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		options outputOptions
+	}{
+		{name: "ndjson", options: outputOptions{Format: FormatNdjson}},
+		{name: "json", options: outputOptions{Format: FormatJSON, Pretty: true}},
+		{name: "es-bulk", options: outputOptions{Format: FormatESBulk, ESIndex: "releases"}},
+		{name: "protobuf", options: outputOptions{Format: FormatProtobuf}},
+		{name: "normalized", options: outputOptions{Format: FormatNdjson, Normalizer: discogs.NewNormalizer(), Markup: markup.FormatText}},
+		{name: "projected", options: outputOptions{Format: FormatNdjson, Fields: newFieldSelection([]string{"id", "title", "tracklist.title"}, nil)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := convertSample(t, tt.options, 1)
+			for _, workers := range []int{2, 8} {
+				got := convertSample(t, tt.options, workers)
+				if !bytes.Equal(got, want) {
+					t.Errorf("%d workers: the output differs from the sequential conversion", workers)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkConvert(b *testing.B) {
+	for _, format := range []string{FormatNdjson, FormatParquet} {
+		// The pipeline is worth it with several CPUs, at least 4 workers
+		// are compared with the sequential conversion.
+		for _, workers := range []int{1, max(runtime.NumCPU(), 4)} {
+			b.Run(fmt.Sprintf("%s/workers=%d", format, workers), func(b *testing.B) {
+				info, err := os.Stat(releasesSample)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(info.Size())
+				b.ReportAllocs()
+
+				for b.Loop() {
+					convertSample(b, outputOptions{Format: format, Parquet: parquetOptions{Codec: CodecZstd, RowGroupSize: 1000000}}, workers)
+				}
+			})
+		}
+	}
+}
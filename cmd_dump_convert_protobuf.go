@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufWriter writes length-delimited Protocol Buffers messages: each
+// message is prefixed with its size as a varint, as read by Java's
+// parseDelimitedFrom or Go's protodelim package.
+type protobufWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func newProtobufWriter(w io.Writer) *protobufWriter {
+	return &protobufWriter{w: w}
+}
+
+func (w *protobufWriter) Write(element any) error {
+	if prepared, ok := element.(*preparedElement); ok {
+		_, err := w.w.Write(prepared.Proto)
+		return err
+	}
+
+	var err error
+	w.buf, err = appendDelimitedProto(w.buf[:0], element)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(w.buf)
+
+	return err
+}
+
+func (w *protobufWriter) Close() error {
+	return nil
+}
+
+// appendDelimitedProto appends the length-delimited message of an element
+// to b.
+func appendDelimitedProto(b []byte, element any) ([]byte, error) {
+	m, ok := element.(interface{ AppendProto(b []byte) []byte })
+	if !ok {
+		return nil, fmt.Errorf("cannot write element of type %T to protobuf", element)
+	}
+
+	message := m.AppendProto(nil)
+	b = protowire.AppendVarint(b, uint64(len(message)))
+
+	return append(b, message...), nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/urfave/cli/v3"
+)
+
+// provenance tells which dump an output was converted from, and how.
+type provenance struct {
+	Source       string            `json:"source"`
+	DumpYear     string            `json:"dump_year,omitempty"`
+	DumpMonth    string            `json:"dump_month,omitempty"`
+	SourceSHA256 string            `json:"source_sha256,omitempty"`
+	Version      string            `json:"dgtools_version"`
+	ConvertedAt  time.Time         `json:"converted_at"`
+	Flags        map[string]string `json:"flags"`
+}
+
+// keyValues returns the provenance as the key/value metadata of a parquet
+// file.
+func (p provenance) keyValues() (map[string]string, error) {
+	flags, err := json.Marshal(p.Flags)
+	if err != nil {
+		return nil, err
+	}
+
+	kv := map[string]string{
+		"dgtools.source":       p.Source,
+		"dgtools.version":      p.Version,
+		"dgtools.converted_at": p.ConvertedAt.Format(time.RFC3339),
+		"dgtools.flags":        string(flags),
+	}
+	// Parts closed before the source was hashed, and conversions stopped by
+	// --stop-after, don't carry its checksum.
+	if p.SourceSHA256 != "" {
+		kv["dgtools.source_sha256"] = p.SourceSHA256
+	}
+	if p.DumpYear != "" {
+		kv["dgtools.dump_year"] = p.DumpYear
+		kv["dgtools.dump_month"] = p.DumpMonth
+	}
+
+	return kv, nil
+}
+
+// sourceProvenance hashes the source of a conversion as the dump is read,
+// so that the largest dumps aren't read twice, and hands out its provenance
+// once the checksum is known.
+//
+// It's used by the goroutine writing the conversion only.
+type sourceProvenance struct {
+	provenance provenance
+	hash       hash.Hash
+	source     io.Reader
+	done       chan struct{}
+	err        error
+	// sidecars are the sidecars of the files closed before the source was
+	// hashed, written once it is.
+	sidecars []func(p provenance) error
+}
+
+func newSourceProvenance(source string, flags map[string]string) *sourceProvenance {
+	p := &sourceProvenance{
+		provenance: provenance{
+			Source:      filepath.Base(source),
+			Version:     VERSION,
+			ConvertedAt: time.Now().UTC(),
+			Flags:       flags,
+		},
+		hash: sha256.New(),
+		done: make(chan struct{}),
+	}
+	if name := discogs.DumpFilename(p.provenance.Source); name.Valid() {
+		p.provenance.DumpYear = name.Year()
+		p.provenance.DumpMonth = name.Month()
+	}
+
+	return p
+}
+
+// reader returns a reader of the source that hashes what's read from it.
+func (p *sourceProvenance) reader(source io.Reader) io.Reader {
+	p.source = io.TeeReader(source, p.hash)
+	return p.source
+}
+
+// finish hashes the rest of the source past the end of the document, and
+// writes the sidecars waiting for it. The source isn't read further when the
+// conversion was stopped before its end, the provenance has no checksum then.
+func (p *sourceProvenance) finish(consumed bool) error {
+	if consumed {
+		_, p.err = io.Copy(io.Discard, p.source)
+		if p.err == nil {
+			p.provenance.SourceSHA256 = hex.EncodeToString(p.hash.Sum(nil))
+		}
+	}
+	close(p.done)
+	if p.err != nil {
+		return p.err
+	}
+
+	for _, write := range p.sidecars {
+		if err := write(p.provenance); err != nil {
+			return err
+		}
+	}
+	p.sidecars = nil
+
+	return nil
+}
+
+// Provenance waits for the source to be hashed.
+func (p *sourceProvenance) Provenance() (provenance, error) {
+	<-p.done
+	return p.provenance, p.err
+}
+
+// current returns the provenance, and false if the source isn't hashed yet.
+// The provenance has no checksum then, nor when the source wasn't read to its
+// end.
+func (p *sourceProvenance) current() (provenance, bool) {
+	select {
+	case <-p.done:
+		return p.provenance, p.err == nil
+	default:
+		return p.provenance, false
+	}
+}
+
+// sidecar writes a sidecar with the provenance, now if the source was
+// hashed already, once it is otherwise.
+func (p *sourceProvenance) sidecar(write func(p provenance) error) error {
+	if provenance, hashed := p.current(); hashed {
+		return write(provenance)
+	}
+	p.sidecars = append(p.sidecars, write)
+
+	return nil
+}
+
+// commandFlags returns the flags set on the command line or through the
+// environment, with their values.
+func commandFlags(cmd *cli.Command) map[string]string {
+	flags := make(map[string]string)
+	for _, name := range cmd.LocalFlagNames() {
+		switch value := cmd.Value(name).(type) {
+		case []string:
+			flags[name] = strings.Join(value, ",")
+		default:
+			flags[name] = fmt.Sprint(value)
+		}
+	}
+
+	return flags
+}
+
+// metadataSetter is implemented by the writers of formats embedding
+// key/value metadata, which don't need a sidecar.
+type metadataSetter interface {
+	SetMetadata(key, value string)
+}
+
+// writeSidecar writes the provenance of an output to name.meta.json.
+func writeSidecar(ctx context.Context, storage outputStorage, name string, p any) error {
+	file, err := storage.Create(ctx, name+".meta.json")
+	if err != nil {
+		return err
+	}
+
+	return writeJSONFile(file, p)
+}
+
+// writeJSONFile encodes v to file and closes it.
+func writeJSONFile(file io.WriteCloser, v any) error {
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/marcw/dgtools/internal/discogs"
+)
+
+// Entities are linked data resources identified by their page on Discogs,
+// described with the schema.org vocabulary.
+const (
+	schemaNamespace = "https://schema.org/"
+	xsdNamespace    = "http://www.w3.org/2001/XMLSchema#"
+	rdfType         = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+	discogsIRI      = "https://www.discogs.com/"
+)
+
+// rdfTerm is the object of a statement, a resource when IRI is set or a
+// literal, typed when Datatype is set.
+type rdfTerm struct {
+	IRI      string
+	Value    string
+	Datatype string
+}
+
+// rdfStatement is a schema.org property of a resource.
+type rdfStatement struct {
+	Property string
+	Object   rdfTerm
+}
+
+type rdfResource struct {
+	IRI        string
+	Type       string
+	Statements []rdfStatement
+}
+
+func (r *rdfResource) literal(property string, value string) {
+	if value != "" {
+		r.Statements = append(r.Statements, rdfStatement{Property: property, Object: rdfTerm{Value: value}})
+	}
+}
+
+func (r *rdfResource) optionalLiteral(property string, value *string) {
+	if value != nil {
+		r.literal(property, *value)
+	}
+}
+
+func (r *rdfResource) typed(property string, value string, datatype string) {
+	r.Statements = append(r.Statements, rdfStatement{Property: property, Object: rdfTerm{Value: value, Datatype: datatype}})
+}
+
+func (r *rdfResource) link(property string, kind string, id int64) {
+	if id != 0 {
+		r.Statements = append(r.Statements, rdfStatement{Property: property, Object: rdfTerm{IRI: entityIRI(kind, id)}})
+	}
+}
+
+// entityIRI returns the stable IRI of an entity, its page on Discogs.
+func entityIRI(kind string, id int64) string {
+	return fmt.Sprintf("%s%s/%d", discogsIRI, kind, id)
+}
+
+// rdfResourceOf maps an entity to schema.org. Artists with members are
+// groups, other artists are persons.
+func rdfResourceOf(element any) (*rdfResource, error) {
+	switch e := element.(type) {
+	case *discogs.Artist:
+		r := &rdfResource{IRI: entityIRI("artist", e.ID), Type: "Person"}
+		if len(e.Members) > 0 {
+			r.Type = "MusicGroup"
+		}
+		r.literal("name", e.Name)
+		for _, variation := range e.NameVariations {
+			r.literal("alternateName", variation)
+		}
+		r.optionalLiteral("description", e.Profile)
+		for _, member := range e.Members {
+			r.link("member", "artist", member.ID)
+		}
+		for _, group := range e.Groups {
+			r.link("memberOf", "artist", group.ID)
+		}
+		return r, nil
+	case *discogs.Label:
+		r := &rdfResource{IRI: entityIRI("label", e.ID), Type: "Organization"}
+		r.literal("name", e.Name)
+		r.optionalLiteral("description", e.Profile)
+		if e.ParentLabelID != nil {
+			r.link("parentOrganization", "label", *e.ParentLabelID)
+		}
+		return r, nil
+	case *discogs.Master:
+		r := &rdfResource{IRI: entityIRI("master", e.ID), Type: "MusicAlbum"}
+		r.literal("name", e.Title)
+		if e.Year != nil && *e.Year > 0 {
+			r.typed("datePublished", fmt.Sprintf("%04d", *e.Year), xsdNamespace+"gYear")
+		}
+		for _, artist := range e.Artists {
+			r.link("byArtist", "artist", artist.ID)
+		}
+		for _, genre := range e.Genres {
+			r.literal("genre", genre)
+		}
+		if e.MainReleaseID != nil {
+			r.link("albumRelease", "release", *e.MainReleaseID)
+		}
+		return r, nil
+	case *discogs.Release:
+		r := &rdfResource{IRI: entityIRI("release", e.ID), Type: "MusicRelease"}
+		r.literal("name", e.Title)
+		if e.Released != nil {
+			if value, datatype, ok := rdfDate(*e.Released); ok {
+				r.typed("datePublished", value, datatype)
+			}
+		}
+		for _, artist := range e.Artists {
+			r.link("creditedTo", "artist", artist.ID)
+		}
+		for _, label := range e.Labels {
+			r.link("recordLabel", "label", label.ID)
+			r.optionalLiteral("catalogNumber", label.Catno)
+		}
+		if e.MasterID != nil {
+			r.link("releaseOf", "master", *e.MasterID)
+		}
+		for _, genre := range e.Genres {
+			r.literal("genre", genre)
+		}
+		return r, nil
+	default:
+		return nil, fmt.Errorf("cannot write element of type %T to linked data", element)
+	}
+}
+
+var releasedDate = regexp.MustCompile(`^(\d{4})(?:-(\d{2})(?:-(\d{2}))?)?$`)
+
+// rdfDate returns the XML Schema literal of a release date. Dates in the
+// dumps may lack a day or a month, which are then zero.
+func rdfDate(released string) (string, string, bool) {
+	m := releasedDate.FindStringSubmatch(released)
+	if m == nil || m[1] == "0000" {
+		return "", "", false
+	}
+
+	switch {
+	case m[2] == "" || m[2] == "00":
+		return m[1], xsdNamespace + "gYear", true
+	case m[3] == "" || m[3] == "00":
+		return m[1] + "-" + m[2], xsdNamespace + "gYearMonth", true
+	}
+	if _, err := time.Parse("2006-01-02", released); err != nil {
+		return m[1] + "-" + m[2], xsdNamespace + "gYearMonth", true
+	}
+
+	return released, xsdNamespace + "date", true
+}
+
+// ntriplesWriter writes one triple per line, which can be split and
+// concatenated at will.
+type ntriplesWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func newNTriplesWriter(w io.Writer) *ntriplesWriter {
+	return &ntriplesWriter{w: w}
+}
+
+func (w *ntriplesWriter) Write(element any) error {
+	r, err := rdfResourceOf(unwrapElement(element))
+	if err != nil {
+		return err
+	}
+
+	w.buf.Reset()
+	fmt.Fprintf(&w.buf, "<%s> <%s> <%s%s> .\n", r.IRI, rdfType, schemaNamespace, r.Type)
+	for _, statement := range r.Statements {
+		fmt.Fprintf(&w.buf, "<%s> <%s%s> ", r.IRI, schemaNamespace, statement.Property)
+		if statement.Object.IRI != "" {
+			fmt.Fprintf(&w.buf, "<%s>", statement.Object.IRI)
+		} else {
+			w.buf.WriteString(ntriplesLiteral(statement.Object.Value))
+			if statement.Object.Datatype != "" {
+				fmt.Fprintf(&w.buf, "^^<%s>", statement.Object.Datatype)
+			}
+		}
+		w.buf.WriteString(" .\n")
+	}
+
+	_, err = w.w.Write(w.buf.Bytes())
+	return err
+}
+
+func (w *ntriplesWriter) Close() error {
+	return nil
+}
+
+// ntriplesLiteral quotes a string, escaping what N-Triples requires.
+func ntriplesLiteral(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\u%04X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+
+	return sb.String()
+}
+
+// jsonldContext maps the JSON-LD terms to schema.org.
+var jsonldContext = map[string]any{
+	"@vocab": schemaNamespace,
+	"xsd":    xsdNamespace,
+}
+
+// jsonldWriter writes a single JSON-LD document holding every resource in
+// its @graph, one resource per line.
+type jsonldWriter struct {
+	w       io.Writer
+	buf     bytes.Buffer
+	encoder *json.Encoder
+	n       int64
+}
+
+func newJSONLDWriter(w io.Writer) *jsonldWriter {
+	jw := &jsonldWriter{w: w}
+	jw.encoder = json.NewEncoder(&jw.buf)
+	jw.encoder.SetEscapeHTML(false)
+
+	return jw
+}
+
+func (w *jsonldWriter) Write(element any) error {
+	r, err := rdfResourceOf(unwrapElement(element))
+	if err != nil {
+		return err
+	}
+
+	node := map[string]any{
+		"@id":   r.IRI,
+		"@type": r.Type,
+	}
+	for _, statement := range r.Statements {
+		var value any = statement.Object.Value
+		switch {
+		case statement.Object.IRI != "":
+			value = map[string]string{"@id": statement.Object.IRI}
+		case statement.Object.Datatype != "":
+			value = map[string]string{
+				"@value": statement.Object.Value,
+				"@type":  "xsd:" + strings.TrimPrefix(statement.Object.Datatype, xsdNamespace),
+			}
+		}
+
+		switch existing := node[statement.Property].(type) {
+		case nil:
+			node[statement.Property] = value
+		case []any:
+			node[statement.Property] = append(existing, value)
+		default:
+			node[statement.Property] = []any{existing, value}
+		}
+	}
+
+	w.buf.Reset()
+	if w.n == 0 {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	} else {
+		w.buf.WriteString(",\n")
+	}
+	if err := w.encoder.Encode(node); err != nil {
+		return err
+	}
+	w.buf.Truncate(w.buf.Len() - 1)
+	w.n++
+
+	_, err = w.w.Write(w.buf.Bytes())
+	return err
+}
+
+func (w *jsonldWriter) writeHeader() error {
+	context, err := json.Marshal(jsonldContext)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&w.buf, "{\"@context\":%s,\"@graph\":[\n", context)
+
+	return nil
+}
+
+func (w *jsonldWriter) Close() error {
+	w.buf.Reset()
+	if w.n == 0 {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+	w.buf.WriteString("\n]}\n")
+
+	_, err := w.w.Write(w.buf.Bytes())
+	return err
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/urfave/cli/v3"
+)
+
+// outputStorage is where converted files are created, either the local
+// filesystem or an S3-compatible bucket.
+type outputStorage interface {
+	// Create creates a file. Cancelling ctx aborts writing it.
+	Create(ctx context.Context, name string) (io.WriteCloser, error)
+	MkdirAll(dir string) error
+}
+
+type localStorage struct{}
+
+func (localStorage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (localStorage) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+// newOutputStorage returns the storage an output location lives in, along
+// with the path of the output within that storage. s3://bucket/key
+// locations are stored in S3, anything else on the local filesystem.
+func newOutputStorage(cmd *cli.Command, location string) (outputStorage, string, error) {
+	if !strings.HasPrefix(location, "s3://") {
+		return localStorage{}, location, nil
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	if bucket == "" {
+		return nil, "", fmt.Errorf("missing bucket in %s", location)
+	}
+
+	storage, err := newS3Storage(s3Options{
+		Endpoint:  cmd.String("s3-endpoint"),
+		Region:    cmd.String("s3-region"),
+		PathStyle: cmd.Bool("s3-path-style"),
+		PartSize:  cmd.Int64("s3-part-size"),
+	}, bucket)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return storage, key, nil
+}
+
+type s3Options struct {
+	Endpoint  string
+	Region    string
+	PathStyle bool
+	PartSize  int64
+}
+
+// s3Storage streams files to an S3-compatible bucket with multipart uploads,
+// so that nothing is staged on the local disk.
+//
+// Credentials are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables, or MINIO_ROOT_USER and
+// MINIO_ROOT_PASSWORD.
+type s3Storage struct {
+	client   *minio.Client
+	bucket   string
+	partSize uint64
+}
+
+func newS3Storage(o s3Options, bucket string) (*s3Storage, error) {
+	endpoint := o.Endpoint
+	secure := true
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint %s: %w", o.Endpoint, err)
+		}
+		endpoint = u.Host
+		secure = u.Scheme != "http"
+	}
+
+	lookup := minio.BucketLookupAuto
+	if o.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+		}),
+		Secure:       secure,
+		Region:       o.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client.SetAppInfo("dgtools", VERSION)
+
+	return &s3Storage{client: client, bucket: bucket, partSize: uint64(o.PartSize)}, nil
+}
+
+// Create starts the upload of an object, which is completed when the
+// returned writer is closed.
+func (s *s3Storage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	key := filepath.ToSlash(name)
+	if key == "" || strings.HasSuffix(key, "/") {
+		return nil, fmt.Errorf("invalid object key %q in bucket %s", key, s.bucket)
+	}
+
+	pr, pw := io.Pipe()
+	object := &s3Object{pw: pw, done: make(chan error, 1)}
+
+	// Cancelling ctx fails the upload through the pipe rather than through
+	// the client, which needs a live context to abort the multipart upload.
+	object.stop = context.AfterFunc(ctx, func() {
+		pw.CloseWithError(context.Cause(ctx))
+	})
+
+	go func() {
+		_, err := s.client.PutObject(context.WithoutCancel(ctx), s.bucket, key, pr, -1, minio.PutObjectOptions{
+			PartSize: s.partSize,
+		})
+		if err != nil {
+			err = fmt.Errorf("uploading s3://%s/%s: %w", s.bucket, key, err)
+		}
+		// Unblock the writer if the upload failed early.
+		pr.CloseWithError(err)
+		object.done <- err
+	}()
+
+	return object, nil
+}
+
+// MkdirAll does nothing, S3 has no directories.
+func (s *s3Storage) MkdirAll(dir string) error {
+	return nil
+}
+
+// s3Object is an object being uploaded to S3.
+type s3Object struct {
+	pw   *io.PipeWriter
+	done chan error
+	stop func() bool
+}
+
+func (o *s3Object) Write(p []byte) (int, error) {
+	return o.pw.Write(p)
+}
+
+// Close completes the upload and waits for it.
+func (o *s3Object) Close() error {
+	o.stop()
+	o.pw.Close()
+
+	return <-o.done
+}
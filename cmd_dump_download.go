@@ -3,34 +3,46 @@ package main
 import (
 	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"strings"
 	"time"
 
-	"github.com/briandowns/spinner"
+	"github.com/marcw/dgtools/internal/discogs"
 	"github.com/urfave/cli/v3"
 )
 
 var discogsDumpDownloadCmd = &cli.Command{
 	Name:  "download",
-	Usage: "Download a Discogs data dump",
+	Usage: "Download a Discogs data dump, or all the dumps of a month",
 	Arguments: []cli.Argument{
 		&cli.StringArg{
 			Name:      "name",
 			UsageText: "The file to download",
 		},
 	},
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:  "out-dir",
 			Usage: "The output directory",
 			Value: ".",
 		},
+		&cli.StringFlag{
+			Name:  "month",
+			Usage: "Download the four dumps of a month (YYYY-MM) and their CHECKSUM file",
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if _, err := time.Parse("2006-01", s); err != nil {
+					return fmt.Errorf("month must be formatted as YYYY-MM")
+				}
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:  "latest",
+			Usage: "Download the dumps of the latest month with the dumps of all the types published",
+		},
 		&cli.BoolFlag{
 			Name:  "overwrite",
 			Usage: "Force the download even if the file already exists",
@@ -41,15 +53,21 @@ var discogsDumpDownloadCmd = &cli.Command{
 			Usage: "Check the checksum of the file after downloading",
 			Value: true,
 		},
-	},
+	}, downloadFlags()...),
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		name := cmd.StringArg("name")
 		outDir := cmd.String("out-dir")
 		overwrite := cmd.Bool("overwrite")
 		checksum := cmd.Bool("checksum")
 
-		if name == "" {
-			return fmt.Errorf("name is required")
+		sources := 0
+		for _, set := range []bool{name != "", cmd.String("month") != "", cmd.Bool("latest")} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("exactly one of a name, --month or --latest is required")
 		}
 
 		_, err := os.Stat(outDir)
@@ -60,62 +78,131 @@ var discogsDumpDownloadCmd = &cli.Command{
 		if outDir == "." {
 			outDir = ""
 		}
-		outFilename := filepath.Join(outDir, filepath.Base(name))
-		if _, err := os.Stat(outFilename); !os.IsNotExist(err) && !overwrite {
-			return fmt.Errorf("file already exists: %s", outFilename)
-		}
 
-		s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
-		s.Suffix = " Downloading..."
-		s.Start()
-		url := fmt.Sprintf("%s/%s", cmd.String("discogs-bucket"), name)
-		resp, err := http.Get(url)
+		options := defaultDownloadOptions
+		options.Retries = cmd.Int("retries")
+		client, err := newBucketClient(cmd)
 		if err != nil {
 			return err
 		}
-		defer resp.Body.Close()
 
-		out := filepath.Base(name)
-		outFile, err := os.Create(out)
-		if err != nil {
-			return err
+		if name == "" {
+			month, err := resolveBucketMonth(ctx, newBucketLister(cmd, client), cmd.String("month"))
+			if err != nil {
+				return err
+			}
+			return downloadMonth(ctx, cmd, client, month, outDir, options)
 		}
-		defer outFile.Close()
 
-		io.Copy(outFile, resp.Body)
-		s.Stop()
-		fmt.Println("Downloaded")
+		outFilename := filepath.Join(outDir, filepath.Base(name))
+		if _, err := os.Stat(outFilename); !os.IsNotExist(err) && !overwrite {
+			return fmt.Errorf("file already exists: %s", outFilename)
+		}
 
+		// The CHECKSUM file is downloaded next to the dump first, so that
+		// the dump is verified while it's downloaded.
 		if checksum {
-			s.Suffix = " Verifying checksum..."
-			s.Start()
-			checksumFile := regexp.MustCompile("(artists|releases|masters|labels)").ReplaceAllString(name, "CHECKSUM")
-			checksumFile = strings.Replace(checksumFile, ".xml.gz", ".txt", 1)
-			resp, err := http.Get(fmt.Sprintf("%s/%s", cmd.String("discogs-bucket"), checksumFile))
-			if err != nil {
-				return err
+			fn := discogs.DumpFilename(filepath.Base(name))
+			if !fn.Valid() {
+				return fmt.Errorf("cannot tell the CHECKSUM file of %s, use --checksum=false", name)
 			}
-			defer resp.Body.Close()
-			outChecksumFile, err := os.Create(filepath.Join(outDir, filepath.Base(checksumFile)))
+			checksums, err := downloadChecksums(ctx, client, path.Join(path.Dir(name), fn.ChecksumFilename()), outDir, overwrite, options)
 			if err != nil {
 				return err
 			}
-			defer outChecksumFile.Close()
-			io.Copy(outChecksumFile, resp.Body)
-
-			// run sha256sum -c outChecksumFile in the output directory
-			sha256sum := exec.Command("sha256sum", "--ignore-missing", "-c", filepath.Base(checksumFile))
-			if outDir != "" {
-				sha256sum.Dir = outDir
-			}
-			err = sha256sum.Run()
-			if err != nil {
-				return fmt.Errorf("failed to check checksum: %w", err)
+			sum, exists := checksums.Lookup(name)
+			if !exists {
+				return fmt.Errorf("%s is not listed in %s", name, fn.ChecksumFilename())
 			}
-			s.Stop()
+			options.SHA256 = sum
+		}
+
+		progress := newDownloadProgress(cmd)
+		if progress != nil {
+			options.Progress = progress.Track(filepath.Base(name), 0)
+			progress.Start()
+		}
+		sum, err := downloadFile(ctx, client, name, outFilename, options)
+		if progress != nil {
+			progress.Stop()
+		}
+		if err != nil {
+			return err
+		}
+		recordDownload(cmd, outFilename, name, sum, checksum)
+		fmt.Println("Downloaded")
+		if checksum {
 			fmt.Println("Checksum OK")
 		}
 
 		return nil
 	},
 }
+
+// downloadFlags returns the flags tuning downloads, shared by the commands
+// downloading dumps.
+func downloadFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:  "parallel",
+			Usage: "The maximum number of files downloaded concurrently",
+			Value: 4,
+			Action: func(ctx context.Context, cmd *cli.Command, v int) error {
+				if v < 1 {
+					return fmt.Errorf("--parallel must be at least 1")
+				}
+				return nil
+			},
+		},
+		&cli.IntFlag{
+			Name:  "retries",
+			Usage: "The number of times a download failing without making progress is retried",
+			Value: defaultDownloadOptions.Retries,
+		},
+		&cli.StringFlag{
+			Name:  "progress",
+			Usage: "How to show the progress: auto, bar, log or json. auto shows a bar on terminals and log lines otherwise",
+			Value: ProgressAuto,
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if !slices.Contains(progressModes, s) {
+					return fmt.Errorf("supported progress modes are: %s", strings.Join(progressModes, ", "))
+				}
+				return nil
+			},
+		},
+		&cli.DurationFlag{
+			Name:  "progress-interval",
+			Usage: "The interval between two log lines or JSON events of progress",
+			Value: 10 * time.Second,
+		},
+		&cli.BoolFlag{
+			Name:  "no-progress",
+			Usage: "Don't show any progress",
+			Value: false,
+		},
+	}
+}
+
+// newDownloadProgress returns the progress reporter configured by the flags,
+// or nil with --no-progress.
+func newDownloadProgress(cmd *cli.Command) *progressReporter {
+	if cmd.Bool("no-progress") {
+		return nil
+	}
+
+	return newProgressReporter(cmd.String("progress"), cmd.Duration("progress-interval"))
+}
+
+// downloadChecksums downloads the CHECKSUM file at key to outDir, unless it
+// was already downloaded, and reads it.
+func downloadChecksums(ctx context.Context, client *bucketClient, key, outDir string, overwrite bool, options downloadOptions) (discogs.Checksums, error) {
+	filename := filepath.Join(outDir, path.Base(key))
+	if _, err := os.Stat(filename); os.IsNotExist(err) || overwrite {
+		options.Progress = nil
+		if _, err := downloadFile(ctx, client, key, filename, options); err != nil {
+			return nil, err
+		}
+	}
+
+	return loadChecksums(ctx, client, filename, false)
+}
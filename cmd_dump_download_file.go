@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcw/dgtools/internal/discogs"
+)
+
+// downloadOptions tunes the retries, the verification and the progress of
+// downloadFile.
+type downloadOptions struct {
+	// Retries is the number of times a download failing without making any
+	// progress is retried.
+	Retries int
+	// Backoff is the delay before the first retry, doubled after each
+	// failure up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// SHA256 is the hex-encoded checksum the file must have, if not empty.
+	SHA256 string
+	// Size is the size of the file as listed in the bucket, used to show
+	// the progress when the server doesn't announce it.
+	Size int64
+	// Progress, when not nil, is updated as the file is downloaded.
+	Progress *transfer
+}
+
+var defaultDownloadOptions = downloadOptions{
+	Retries:    5,
+	Backoff:    time.Second,
+	MaxBackoff: time.Minute,
+}
+
+// permanentError is an error that retrying the download won't fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// downloadFile downloads url to dest and returns its hex-encoded SHA-256
+// checksum, computed while downloading. The bytes go to dest.part, renamed
+// to dest once complete and verified, so that dest is never a truncated or
+// corrupted file.
+//
+// An existing dest.part is resumed with a range request, unless the file
+// changed on the server since it was started, as told by its ETag kept in
+// dest.part.etag. Failed downloads are retried with an exponential backoff;
+// the count of retries is reset whenever an attempt made progress. The
+// requests of the attempts aren't retried by the client, which tries the
+// bucket and its mirrors once each.
+func downloadFile(ctx context.Context, client *bucketClient, key, dest string, o downloadOptions) (string, error) {
+	part := dest + ".part"
+	backoff := o.Backoff
+	once := *client
+	once.Retries = 0
+	client = &once
+
+	var checksum string
+	for retries := 0; ; retries++ {
+		written, sum, err := downloadPart(ctx, client, key, part, o)
+		if err == nil {
+			checksum = sum
+			break
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) || ctx.Err() != nil {
+			return "", err
+		}
+		if written > 0 {
+			retries = 0
+			backoff = o.Backoff
+		}
+		if retries >= o.Retries {
+			return "", fmt.Errorf("giving up after %d retries: %w", retries, err)
+		}
+
+		o.Progress.Logf("Download of %s failed: %v, retrying in %s", key, err, backoff)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, o.MaxBackoff)
+	}
+
+	if o.SHA256 != "" && !strings.EqualFold(checksum, o.SHA256) {
+		return "", &permanentError{discardPart(part, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", key, o.SHA256, checksum))}
+	}
+	if err := os.Rename(part, dest); err != nil {
+		return "", err
+	}
+	o.Progress.Finish()
+
+	return checksum, removeIfExists(part + ".etag")
+}
+
+// downloadPart downloads key to part, resuming it when possible, and returns
+// the number of bytes written by this attempt and the checksum of the whole
+// part once complete.
+func downloadPart(ctx context.Context, client *bucketClient, key, part string, o downloadOptions) (int64, string, error) {
+	offset, etag, err := partState(part)
+	if err != nil {
+		return 0, "", err
+	}
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// The whole file is sent again if it changed, or if it's served by
+		// another mirror.
+		header.Set("If-Range", etag)
+	}
+
+	resp, err := client.Get(ctx, key, header)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	url := resp.Request.URL.String()
+
+	size := resp.ContentLength
+	switch resp.StatusCode {
+	case http.StatusOK:
+		offset = 0
+	case http.StatusPartialContent:
+		start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset || resp.Header.Get("ETag") != etag {
+			// Start over rather than appending bytes that may not belong
+			// to the file.
+			return 0, "", discardPart(part, fmt.Errorf("unexpected response to the resumption of %s", url))
+		}
+		size = total
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The previous attempt may have been interrupted right before the
+		// rename.
+		if _, total, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil && total == offset {
+			o.Progress.Update(total, total)
+			checksum, err := discogs.FileSHA256(part)
+			return 0, checksum, err
+		}
+		return 0, "", discardPart(part, fmt.Errorf("cannot resume %s: %s", url, resp.Status))
+	default:
+		err := fmt.Errorf("failed to download %s: %s", url, resp.Status)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return 0, "", err
+		}
+		return 0, "", &permanentError{err}
+	}
+
+	file, err := openPart(part, offset, resp.Header.Get("ETag"))
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	// The bytes downloaded by previous attempts are hashed again.
+	h := sha256.New()
+	if _, err := io.CopyN(h, file, offset); err != nil {
+		return 0, "", err
+	}
+
+	if size < 0 && o.Size > 0 {
+		size = o.Size
+	}
+	progress := &progressWriter{transfer: o.Progress, done: offset, total: size}
+	progress.transfer.Update(offset, size)
+
+	written, err := io.Copy(io.MultiWriter(file, h, progress), resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, "", err
+	}
+
+	if size >= 0 && offset+written != size {
+		return written, "", discardPart(part, fmt.Errorf("downloaded %d bytes of %s, expected %d", offset+written, url, size))
+	}
+
+	return written, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// progressWriter counts the bytes downloaded to update the progress of a
+// transfer.
+type progressWriter struct {
+	transfer *transfer
+	done     int64
+	total    int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.done += int64(len(p))
+	w.transfer.Update(w.done, w.total)
+
+	return len(p), nil
+}
+
+// partState returns the size of a partial download and the ETag of the file
+// it's a part of. The size is 0 if the download can't be resumed.
+func partState(part string) (int64, string, error) {
+	info, err := os.Stat(part)
+	if os.IsNotExist(err) {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	etag, err := os.ReadFile(part + ".etag")
+	if os.IsNotExist(err) || len(etag) == 0 {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	return info.Size(), string(etag), nil
+}
+
+// openPart opens part to read its first offset bytes and write the
+// following ones. A download starting over truncates the file and records
+// the ETag of the new one.
+func openPart(part string, offset int64, etag string) (*os.File, error) {
+	if offset > 0 {
+		return os.OpenFile(part, os.O_RDWR|os.O_APPEND, 0)
+	}
+
+	if err := removeIfExists(part + ".etag"); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		if err := os.WriteFile(part+".etag", []byte(etag), 0o644); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// discardPart removes a partial download that can't be resumed, and returns
+// err, or the error of the removal.
+func discardPart(part string, err error) error {
+	if removeErr := removeIfExists(part); removeErr != nil {
+		return &permanentError{removeErr}
+	}
+	if removeErr := removeIfExists(part + ".etag"); removeErr != nil {
+		return &permanentError{removeErr}
+	}
+
+	return err
+}
+
+func removeIfExists(name string) error {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// parseContentRange parses the Content-Range header of a response, such as
+// "bytes 100-199/1000" or "bytes */1000", and returns the first byte of the
+// range and the size of the file.
+func parseContentRange(header string) (int64, int64, error) {
+	value, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+	rng, size, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+
+	total, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+	if rng == "*" {
+		return 0, total, nil
+	}
+
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+
+	return start, total, nil
+}
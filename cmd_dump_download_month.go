@@ -0,0 +1,185 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/urfave/cli/v3"
+)
+
+// resolveBucketMonth finds the dumps of a month (YYYY-MM) in the listing of
+// the bucket, or those of the latest month with the dumps of all the types
+// and their CHECKSUM file published when month is empty.
+func resolveBucketMonth(ctx context.Context, lister *bucketLister, month string) (*bucketMonth, error) {
+	prefix := ""
+	if year, _, found := strings.Cut(month, "-"); found {
+		prefix = yearPrefix(year)
+	}
+	contents, err := lister.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	months := bucketMonths(contents)
+
+	if month == "" {
+		latest := latestCompleteMonth(months)
+		if len(latest) == 0 {
+			return nil, fmt.Errorf("no month has the dumps of all the types published")
+		}
+		return latest[0], nil
+	}
+
+	for _, m := range months {
+		if m.Month != month {
+			continue
+		}
+		if missing := m.Missing(); len(missing) > 0 {
+			return nil, fmt.Errorf("missing from the dumps of %s: %s", month, strings.Join(missing, ", "))
+		}
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("no dumps for %s", month)
+}
+
+// downloadMonth downloads the dumps of a month to outDir, verifying them
+// with their CHECKSUM file. Dumps already downloaded are verified and kept,
+// unless --overwrite is set.
+func downloadMonth(ctx context.Context, cmd *cli.Command, client *bucketClient, month *bucketMonth, outDir string, options downloadOptions) error {
+	overwrite := cmd.Bool("overwrite")
+	checksum := cmd.Bool("checksum")
+
+	var checksums discogs.Checksums
+	if checksum {
+		var err error
+		checksums, err = downloadChecksums(ctx, client, month.Checksum.Key, outDir, overwrite, options)
+		if err != nil {
+			return err
+		}
+	}
+
+	catalog := commandCatalog(cmd, cmp.Or(outDir, "."))
+	jobs := make([]downloadJob, 0, len(discogs.DumpTypes))
+	for _, dumpType := range discogs.DumpTypes {
+		content := month.Dumps[dumpType]
+		name := path.Base(content.Key)
+		job := downloadJob{Key: content.Key, Dest: filepath.Join(outDir, name), Options: options}
+		job.Options.Size = content.Size
+		if checksum {
+			sum, exists := checksums.Lookup(name)
+			if !exists {
+				return fmt.Errorf("%s is not listed in %s", name, path.Base(month.Checksum.Key))
+			}
+			job.Options.SHA256 = sum
+		}
+
+		if info, err := os.Stat(job.Dest); err == nil && !overwrite {
+			// Dumps verified before aren't hashed again.
+			if checksum && !catalog.verified(info, job.Options.SHA256) {
+				if err := verifyDownloaded(cmd, job.Dest, job.Options.SHA256); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("%s was already downloaded\n", name)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := downloadAll(ctx, cmd, client, jobs); err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		fmt.Printf("Downloaded %s\n", path.Base(job.Key))
+	}
+	if checksum {
+		fmt.Println("Checksums OK")
+	}
+
+	return nil
+}
+
+// downloadJob is a file of the bucket to download.
+type downloadJob struct {
+	Key     string
+	Dest    string
+	Options downloadOptions
+}
+
+// downloadAll downloads files of the bucket, --parallel of them at a time,
+// showing their progress together. The first error cancels the other
+// downloads, whose partial downloads are kept to be resumed.
+func downloadAll(ctx context.Context, cmd *cli.Command, client *bucketClient, jobs []downloadJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	progress := newDownloadProgress(cmd)
+	if progress != nil {
+		for i := range jobs {
+			jobs[i].Options.Progress = progress.Track(path.Base(jobs[i].Key), jobs[i].Options.Size)
+		}
+		progress.Start()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	slots := make(chan struct{}, cmd.Int("parallel"))
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-slots }()
+
+			sum, err := downloadFile(ctx, client, job.Key, job.Dest, job.Options)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("%s: %w", path.Base(job.Key), err)
+					cancel()
+				})
+				return
+			}
+			recordDownload(cmd, job.Dest, job.Key, sum, job.Options.SHA256 != "")
+		}()
+	}
+	wg.Wait()
+
+	if progress != nil {
+		progress.Stop()
+	}
+
+	return firstErr
+}
+
+// verifyDownloaded verifies the checksum of a dump downloaded before.
+func verifyDownloaded(cmd *cli.Command, filename, expected string) error {
+	actual, err := discogs.FileSHA256(filename)
+	if err != nil {
+		return err
+	}
+	matches := strings.EqualFold(actual, expected)
+	recordVerification(cmd, filename, actual, matches)
+	if !matches {
+		return fmt.Errorf("%s already exists and doesn't match its checksum, use --overwrite to download it again", filename)
+	}
+
+	return nil
+}
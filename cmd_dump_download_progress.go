@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/mattn/go-isatty"
+)
+
+const (
+	ProgressAuto = "auto"
+	ProgressBar  = "bar"
+	ProgressLog  = "log"
+	ProgressJSON = "json"
+)
+
+var progressModes = []string{ProgressAuto, ProgressBar, ProgressLog, ProgressJSON}
+
+// progressBarWidth is the number of characters of the progress bar.
+const progressBarWidth = 30
+
+// progressReporter reports the progress of downloads on stderr: as a
+// progress bar redrawn on terminals, or else as log lines or JSON events
+// written every interval, so that logs show the downloads moving.
+type progressReporter struct {
+	mode     string
+	interval time.Duration
+	output   io.Writer
+
+	mu        sync.Mutex
+	transfers []*transfer
+	drawn     bool
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// newProgressReporter creates a reporter in one of progressModes. The auto
+// mode picks a bar on terminals and log lines otherwise.
+func newProgressReporter(mode string, interval time.Duration) *progressReporter {
+	if mode == ProgressAuto {
+		mode = ProgressLog
+		if isatty.IsTerminal(os.Stderr.Fd()) || isatty.IsCygwinTerminal(os.Stderr.Fd()) {
+			mode = ProgressBar
+		}
+	}
+	if mode == ProgressBar {
+		interval = 200 * time.Millisecond
+	}
+
+	return &progressReporter{
+		mode:     mode,
+		interval: interval,
+		output:   os.Stderr,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Start starts reporting the progress every interval, until Stop.
+func (r *progressReporter) Start() {
+	go func() {
+		defer close(r.stopped)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.report()
+			}
+		}
+	}()
+}
+
+// Stop reports the final progress and stops reporting.
+func (r *progressReporter) Stop() {
+	close(r.stop)
+	<-r.stopped
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mode == ProgressBar && r.drawn {
+		r.drawBar()
+		fmt.Fprintln(r.output)
+		r.drawn = false
+	}
+}
+
+// Track starts tracking the download of a file. size is the size of the
+// file as listed in the bucket, or 0 if unknown until the download starts.
+func (r *progressReporter) Track(name string, size int64) *transfer {
+	t := &transfer{reporter: r, name: name}
+	t.started.Store(time.Now().UnixNano())
+	t.total.Store(size)
+	t.resumed.Store(-1)
+
+	r.mu.Lock()
+	r.transfers = append(r.transfers, t)
+	r.mu.Unlock()
+
+	return t
+}
+
+func (r *progressReporter) report() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch r.mode {
+	case ProgressBar:
+		r.drawBar()
+	case ProgressLog, ProgressJSON:
+		for _, t := range r.transfers {
+			// Queued downloads aren't reported until they start.
+			if !t.finished.Load() && t.resumed.Load() >= 0 {
+				r.logTransfer("progress", t.snapshot())
+			}
+		}
+	}
+}
+
+// drawBar redraws the progress bar of all the downloads on the current line.
+func (r *progressReporter) drawBar() {
+	total := transferSnapshot{}
+	running := make([]string, 0)
+	for _, t := range r.transfers {
+		s := t.snapshot()
+		total.Done += s.Done
+		total.Total += s.Total
+		total.Downloaded += s.Downloaded
+		total.Elapsed = max(total.Elapsed, s.Elapsed)
+		if !t.finished.Load() {
+			running = append(running, s.Name)
+		}
+	}
+	switch {
+	case len(r.transfers) == 1:
+		total.Name = r.transfers[0].name
+	case len(running) == 1:
+		total.Name = fmt.Sprintf("%d files, %s", len(r.transfers), running[0])
+	default:
+		total.Name = fmt.Sprintf("%d files", len(r.transfers))
+	}
+
+	filled := 0
+	if total.Total > 0 {
+		filled = int(min(total.Done, total.Total) * progressBarWidth / total.Total)
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	fmt.Fprintf(r.output, "\r\033[K%s [%s] %s", total.Name, bar, total.String())
+	r.drawn = true
+}
+
+// logTransfer writes a log line or a JSON event about a download.
+func (r *progressReporter) logTransfer(event string, s transferSnapshot) {
+	if r.mode == ProgressJSON {
+		data, err := json.Marshal(s.event(event))
+		if err == nil {
+			fmt.Fprintln(r.output, string(data))
+		}
+		return
+	}
+
+	if event == "done" {
+		fmt.Fprintf(r.output, "%s downloaded: %s in %s\n", s.Name, humanize.Bytes(uint64(s.Done)), s.Elapsed.Round(time.Second))
+		return
+	}
+	fmt.Fprintf(r.output, "Downloading %s: %s\n", s.Name, s.String())
+}
+
+// logf writes a message without garbling the progress bar.
+func (r *progressReporter) logf(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mode == ProgressBar && r.drawn {
+		fmt.Fprint(r.output, "\r\033[K")
+		r.drawn = false
+	}
+	fmt.Fprintf(r.output, format+"\n", args...)
+}
+
+// transfer is the progress of a download, updated by downloadFile.
+type transfer struct {
+	reporter *progressReporter
+	name     string
+	// started is the time the download started, in nanoseconds since the
+	// epoch, reset when the first bytes come in as downloads may be queued.
+	started atomic.Int64
+	done    atomic.Int64
+	total   atomic.Int64
+	// resumed is the number of bytes downloaded by a previous run, or -1
+	// until the download starts.
+	resumed  atomic.Int64
+	finished atomic.Bool
+	// elapsed is the duration of the download, once finished.
+	elapsed time.Duration
+}
+
+// Update records the number of bytes of the file downloaded so far, and its
+// size when it's known.
+func (t *transfer) Update(done, total int64) {
+	if t == nil {
+		return
+	}
+	if t.resumed.CompareAndSwap(-1, done) {
+		t.started.Store(time.Now().UnixNano())
+	}
+	// A download starting over downloads the bytes of the previous run
+	// again.
+	if done < t.resumed.Load() {
+		t.resumed.Store(done)
+	}
+	t.done.Store(done)
+	if total > 0 {
+		t.total.Store(total)
+	}
+}
+
+// Finish reports the end of a successful download.
+func (t *transfer) Finish() {
+	if t == nil {
+		return
+	}
+	t.elapsed = time.Since(time.Unix(0, t.started.Load()))
+	t.finished.Store(true)
+
+	r := t.reporter
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mode != ProgressBar {
+		r.logTransfer("done", t.snapshot())
+	}
+}
+
+// Logf reports a message about the download, such as a retry.
+func (t *transfer) Logf(format string, args ...any) {
+	if t == nil {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+		return
+	}
+	t.reporter.logf(format, args...)
+}
+
+// transferSnapshot is the progress of a download at some point.
+type transferSnapshot struct {
+	Name  string
+	Done  int64
+	Total int64
+	// Downloaded is the number of bytes downloaded by this run, the bytes
+	// of resumed downloads left out.
+	Downloaded int64
+	Elapsed    time.Duration
+}
+
+func (t *transfer) snapshot() transferSnapshot {
+	done := t.done.Load()
+	elapsed := time.Since(time.Unix(0, t.started.Load()))
+	if t.finished.Load() {
+		elapsed = t.elapsed
+	}
+
+	return transferSnapshot{
+		Name:       t.name,
+		Done:       done,
+		Total:      t.total.Load(),
+		Downloaded: done - max(t.resumed.Load(), 0),
+		Elapsed:    elapsed,
+	}
+}
+
+// speed returns the number of bytes downloaded per second.
+func (s transferSnapshot) speed() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+
+	return float64(s.Downloaded) / s.Elapsed.Seconds()
+}
+
+// eta returns the time left to complete the download, and false if it can't
+// be estimated.
+func (s transferSnapshot) eta() (time.Duration, bool) {
+	speed := s.speed()
+	if s.Total <= 0 || speed <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(float64(s.Total-s.Done) / speed * float64(time.Second)), true
+}
+
+func (s transferSnapshot) percent() float64 {
+	if s.Total <= 0 {
+		return 0
+	}
+
+	return float64(s.Done) * 100 / float64(s.Total)
+}
+
+// String returns the bytes downloaded, the percentage, the throughput and
+// the ETA of the download.
+func (s transferSnapshot) String() string {
+	parts := make([]string, 0, 4)
+	if s.Total > 0 {
+		parts = append(parts,
+			fmt.Sprintf("%s / %s", humanize.Bytes(uint64(s.Done)), humanize.Bytes(uint64(s.Total))),
+			fmt.Sprintf("%.1f%%", s.percent()),
+		)
+	} else {
+		parts = append(parts, humanize.Bytes(uint64(s.Done)))
+	}
+	parts = append(parts, fmt.Sprintf("%.1f MB/s", s.speed()/1e6))
+	if eta, ok := s.eta(); ok {
+		parts = append(parts, "ETA "+eta.Round(time.Second).String())
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+// progressEvent is a JSON progress event.
+type progressEvent struct {
+	Event          string  `json:"event"`
+	File           string  `json:"file"`
+	Bytes          int64   `json:"bytes"`
+	TotalBytes     int64   `json:"total_bytes,omitempty"`
+	Percent        float64 `json:"percent,omitempty"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	ETASeconds     float64 `json:"eta_seconds,omitempty"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+func (s transferSnapshot) event(event string) progressEvent {
+	e := progressEvent{
+		Event:          event,
+		File:           s.Name,
+		Bytes:          s.Done,
+		TotalBytes:     s.Total,
+		Percent:        math.Round(s.percent()*10) / 10,
+		BytesPerSecond: math.Round(s.speed()),
+		ElapsedSeconds: math.Round(s.Elapsed.Seconds()*10) / 10,
+	}
+	if eta, ok := s.eta(); ok && event != "done" {
+		e.ETASeconds = math.Round(eta.Seconds())
+	}
+
+	return e
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/urfave/cli/v3"
+)
+
+var discogsDumpESMappingCmd = &cli.Command{
+	Name:  "es-mapping",
+	Usage: "Print the Elasticsearch/OpenSearch index mapping of the documents written by dump convert --format es-bulk",
+	Arguments: []cli.Argument{
+		&cli.StringArg{
+			Name:      "type",
+			UsageText: "The type of dump: artists, labels, masters or releases",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		dumpType := cmd.StringArg("type")
+		if !slices.Contains(discogs.DumpTypes, dumpType) {
+			return fmt.Errorf("supported types are: %s", strings.Join(discogs.DumpTypes, ", "))
+		}
+
+		var entity any
+		switch dumpType {
+		case "artists":
+			entity = &discogs.Artist{}
+		case "labels":
+			entity = &discogs.Label{}
+		case "masters":
+			entity = &discogs.Master{}
+		case "releases":
+			entity = &discogs.Release{}
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(esMapping(entity))
+	},
+}
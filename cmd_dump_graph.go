@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	GraphFormatNeo4j   = "neo4j"
+	GraphFormatGraphML = "graphml"
+	GraphFormatGEXF    = "gexf"
+)
+
+var graphFormats = []string{GraphFormatNeo4j, GraphFormatGraphML, GraphFormatGEXF}
+
+// Kinds of nodes, named after Neo4j labels.
+const (
+	NodeArtist  = "Artist"
+	NodeLabel   = "Label"
+	NodeMaster  = "Master"
+	NodeRelease = "Release"
+)
+
+var nodeKinds = []string{NodeArtist, NodeLabel, NodeMaster, NodeRelease}
+
+// Types of edges, named after Neo4j relationship types.
+const (
+	EdgeAliasOf    = "ALIAS_OF"
+	EdgeMemberOf   = "MEMBER_OF"
+	EdgeSublabelOf = "SUBLABEL_OF"
+	EdgeArtistOf   = "ARTIST_OF"
+	EdgeCreditedOn = "CREDITED_ON"
+	EdgeReleasedOn = "RELEASED_ON"
+	EdgeVersionOf  = "VERSION_OF"
+)
+
+var edgeTypes = []string{EdgeAliasOf, EdgeMemberOf, EdgeSublabelOf, EdgeArtistOf, EdgeCreditedOn, EdgeReleasedOn, EdgeVersionOf}
+
+// graphProperty is a property of the nodes of a kind or the edges of a type.
+// Type is one of "string", "int" or "boolean".
+type graphProperty struct {
+	Name string
+	Type string
+}
+
+var nodeProperties = map[string][]graphProperty{
+	NodeArtist:  {{"name", "string"}},
+	NodeLabel:   {{"name", "string"}},
+	NodeMaster:  {{"title", "string"}, {"year", "int"}},
+	NodeRelease: {{"title", "string"}, {"country", "string"}, {"released", "string"}},
+}
+
+var edgeProperties = map[string][]graphProperty{
+	EdgeCreditedOn: {{"role", "string"}},
+	EdgeReleasedOn: {{"catno", "string"}},
+	EdgeVersionOf:  {{"is_main_release", "boolean"}},
+}
+
+// graphNode is an entity of the dumps. Values are aligned with the
+// properties of its kind, nil when missing.
+type graphNode struct {
+	Kind   string
+	ID     int64
+	Values []any
+}
+
+// graphEdge is a relationship between two entities. Values are aligned with
+// the properties of its type, nil when missing.
+type graphEdge struct {
+	Type     string
+	FromKind string
+	From     int64
+	ToKind   string
+	To       int64
+	Values   []any
+}
+
+type graphWriter interface {
+	WriteNode(node graphNode) error
+	WriteEdge(edge graphEdge) error
+	Close() error
+}
+
+var discogsDumpGraphCmd = &cli.Command{
+	Name:  "graph",
+	Usage: "Export the network of artists, labels, masters and releases of Discogs data dumps",
+	Arguments: []cli.Argument{
+		&cli.StringArgs{
+			Name:      "files",
+			UsageText: "The dump files to export",
+			Min:       1,
+			Max:       -1,
+		},
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Sets the output format of the graph",
+			Value: GraphFormatNeo4j,
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if !slices.Contains(graphFormats, s) {
+					return fmt.Errorf("supported formats are: %s", strings.Join(graphFormats, ", "))
+				}
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:     "out",
+			Usage:    "The output directory for neo4j, the output file for graphml and gexf",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "no-progress",
+			Usage: "Don't show any progress bar",
+			Value: false,
+		},
+		&cli.Int64Flag{
+			Name:  "stop-after",
+			Usage: "Stop reading each dump after X records",
+			Value: 0,
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		files := cmd.StringArgs("files")
+		if len(files) == 0 {
+			return fmt.Errorf("at least one dump file is required")
+		}
+		noProgress := cmd.Bool("no-progress")
+
+		// Sources are hashed while they are exported.
+		provenances := make([]*sourceProvenance, len(files))
+		for i, file := range files {
+			provenances[i] = newSourceProvenance(file, commandFlags(cmd))
+		}
+
+		var writer graphWriter
+		var err error
+		switch cmd.String("format") {
+		case GraphFormatNeo4j:
+			writer, err = newNeo4jGraphWriter(cmd.String("out"))
+		case GraphFormatGraphML:
+			writer, err = newGraphMLWriter(cmd.String("out"))
+		case GraphFormatGEXF:
+			writer, err = newGEXFWriter(cmd.String("out"))
+		}
+		if err != nil {
+			return err
+		}
+
+		s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+		if !noProgress {
+			s.Suffix = " Exporting..."
+			s.Start()
+		}
+		now := time.Now()
+
+		var nodes, edges int64
+		for i, file := range files {
+			n, e, err := exportGraph(ctx, file, provenances[i], writer, cmd.Int64("stop-after"), func(nodes int64) {
+				if !noProgress {
+					s.Suffix = fmt.Sprintf(" Exporting %s... %d", file, nodes)
+				}
+			})
+			nodes += n
+			edges += e
+			if err != nil {
+				writer.Close()
+				return err
+			}
+		}
+
+		if err := writer.Close(); err != nil {
+			return err
+		}
+
+		sources := make([]provenance, len(provenances))
+		for i, p := range provenances {
+			if sources[i], err = p.Provenance(); err != nil {
+				return err
+			}
+		}
+		if err := writeSidecar(ctx, localStorage{}, strings.TrimSuffix(cmd.String("out"), "/"), sources); err != nil {
+			return err
+		}
+
+		if !noProgress {
+			s.Stop()
+			fmt.Printf("Exported %d nodes and %d edges in %s.\n", nodes, edges, time.Since(now))
+		}
+
+		return nil
+	},
+}
+
+// exportGraph writes the nodes and edges of the entities of a dump, and
+// returns how many of them were written.
+func exportGraph(ctx context.Context, file string, provenance *sourceProvenance, writer graphWriter, stopAfter int64, progress func(nodes int64)) (int64, int64, error) {
+	source, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer source.Close()
+	dump, err := discogs.NewDump(provenance.reader(source), strings.HasSuffix(file, ".gz"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer dump.Close()
+
+	var nodes, edges int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nodes, edges, err
+		}
+
+		element, err := dump.DecodeNextElement()
+		if err == io.EOF {
+			return nodes, edges, provenance.finish(true)
+		}
+		if err != nil {
+			return nodes, edges, err
+		}
+		if element == nil {
+			continue
+		}
+
+		node, nodeEdges, err := graphOf(element)
+		if err != nil {
+			return nodes, edges, err
+		}
+		if err := writer.WriteNode(node); err != nil {
+			return nodes, edges, err
+		}
+		nodes++
+		for _, edge := range nodeEdges {
+			if err := writer.WriteEdge(edge); err != nil {
+				return nodes, edges, err
+			}
+			edges++
+		}
+
+		if stopAfter != 0 && nodes >= stopAfter {
+			return nodes, edges, provenance.finish(false)
+		}
+		if nodes%1000 == 0 {
+			progress(nodes)
+		}
+	}
+}
+
+// graphOf returns the node of an entity and the edges going out of it.
+//
+// Symmetric relationships are only taken from one side: aliases from every
+// artist, memberships from the members of groups and label hierarchies from
+// the parent of sublabels. Edges to entities without ID are left out.
+func graphOf(element any) (graphNode, []graphEdge, error) {
+	edges := make([]graphEdge, 0)
+	edge := func(edgeType string, fromKind string, from int64, toKind string, to int64, values ...any) {
+		if from == 0 || to == 0 {
+			return
+		}
+		edges = append(edges, graphEdge{Type: edgeType, FromKind: fromKind, From: from, ToKind: toKind, To: to, Values: values})
+	}
+
+	switch e := element.(type) {
+	case *discogs.Artist:
+		for _, alias := range e.Aliases {
+			edge(EdgeAliasOf, NodeArtist, e.ID, NodeArtist, alias.ID)
+		}
+		for _, member := range e.Members {
+			edge(EdgeMemberOf, NodeArtist, member.ID, NodeArtist, e.ID)
+		}
+		return graphNode{Kind: NodeArtist, ID: e.ID, Values: []any{e.Name}}, edges, nil
+	case *discogs.Label:
+		if e.ParentLabelID != nil {
+			edge(EdgeSublabelOf, NodeLabel, e.ID, NodeLabel, *e.ParentLabelID)
+		}
+		return graphNode{Kind: NodeLabel, ID: e.ID, Values: []any{e.Name}}, edges, nil
+	case *discogs.Master:
+		for _, artist := range e.Artists {
+			edge(EdgeArtistOf, NodeArtist, artist.ID, NodeMaster, e.ID)
+		}
+		return graphNode{Kind: NodeMaster, ID: e.ID, Values: []any{e.Title, e.Year}}, edges, nil
+	case *discogs.Release:
+		for _, artist := range e.Artists {
+			edge(EdgeArtistOf, NodeArtist, artist.ID, NodeRelease, e.ID)
+		}
+		for _, artist := range e.ExtraArtists {
+			edge(EdgeCreditedOn, NodeArtist, artist.ID, NodeRelease, e.ID, artist.Role)
+		}
+		for _, label := range e.Labels {
+			edge(EdgeReleasedOn, NodeRelease, e.ID, NodeLabel, label.ID, label.Catno)
+		}
+		if e.MasterID != nil {
+			edge(EdgeVersionOf, NodeRelease, e.ID, NodeMaster, *e.MasterID, e.IsMainRelease)
+		}
+		return graphNode{Kind: NodeRelease, ID: e.ID, Values: []any{e.Title, e.Country, e.Released}}, edges, nil
+	default:
+		return graphNode{}, nil, fmt.Errorf("cannot export element of type %T to a graph", element)
+	}
+}
+
+// graphValue dereferences a property value, ok is false when it's missing.
+func graphValue(value any) (any, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, false
+	case *string:
+		if v == nil {
+			return nil, false
+		}
+		return *v, true
+	case *int32:
+		if v == nil {
+			return nil, false
+		}
+		return *v, true
+	default:
+		return v, true
+	}
+}
+
+// graphID returns an ID of a node unique across kinds.
+func graphID(kind string, id int64) string {
+	return fmt.Sprintf("%s:%d", strings.ToLower(kind), id)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// neo4jGraphWriter writes the CSV files of neo4j-admin database import, a
+// file per kind of node and a file per type of edge and kinds of nodes it
+// links. Files are created as needed.
+type neo4jGraphWriter struct {
+	dir   string
+	files map[string]*neo4jFile
+}
+
+type neo4jFile struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func newNeo4jGraphWriter(dir string) (*neo4jGraphWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &neo4jGraphWriter{dir: dir, files: make(map[string]*neo4jFile)}, nil
+}
+
+func (w *neo4jGraphWriter) WriteNode(node graphNode) error {
+	name := strings.ToLower(node.Kind) + "s.csv"
+	f, err := w.file(name, func() []string {
+		header := []string{fmt.Sprintf("id:ID(%s)", node.Kind)}
+		for _, property := range nodeProperties[node.Kind] {
+			header = append(header, neo4jColumn(property))
+		}
+		return append(header, ":LABEL")
+	})
+	if err != nil {
+		return err
+	}
+
+	record := []string{fmt.Sprint(node.ID)}
+	record = append(record, neo4jValues(node.Values)...)
+
+	return f.writer.Write(append(record, node.Kind))
+}
+
+func (w *neo4jGraphWriter) WriteEdge(edge graphEdge) error {
+	name := fmt.Sprintf("%s_%s_%s.csv", strings.ToLower(edge.Type), strings.ToLower(edge.FromKind), strings.ToLower(edge.ToKind))
+	f, err := w.file(name, func() []string {
+		header := []string{fmt.Sprintf(":START_ID(%s)", edge.FromKind), fmt.Sprintf(":END_ID(%s)", edge.ToKind)}
+		for _, property := range edgeProperties[edge.Type] {
+			header = append(header, neo4jColumn(property))
+		}
+		return append(header, ":TYPE")
+	})
+	if err != nil {
+		return err
+	}
+
+	record := []string{fmt.Sprint(edge.From), fmt.Sprint(edge.To)}
+	record = append(record, neo4jValues(edge.Values)...)
+
+	return f.writer.Write(append(record, edge.Type))
+}
+
+// file returns the file of the given name, creating it with the given header
+// the first time.
+func (w *neo4jGraphWriter) file(name string, header func() []string) (*neo4jFile, error) {
+	if f, exists := w.files[name]; exists {
+		return f, nil
+	}
+
+	file, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	f := &neo4jFile{file: file, writer: csv.NewWriter(file)}
+	w.files[name] = f
+
+	return f, f.writer.Write(header())
+}
+
+func (w *neo4jGraphWriter) Close() error {
+	var firstErr error
+	for _, f := range w.files {
+		f.writer.Flush()
+		if err := f.writer.Error(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := f.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func neo4jColumn(property graphProperty) string {
+	if property.Type == "string" {
+		return property.Name
+	}
+
+	return property.Name + ":" + property.Type
+}
+
+// neo4jValues formats property values, missing values are empty fields,
+// which neo4j-admin doesn't set.
+func neo4jValues(values []any) []string {
+	fields := make([]string, len(values))
+	for i, value := range values {
+		if v, ok := graphValue(value); ok {
+			fields[i] = fmt.Sprint(v)
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// graphPropertyUnion returns the properties of all the kinds of nodes or
+// types of edges, without duplicates.
+func graphPropertyUnion(keys []string, properties map[string][]graphProperty) []graphProperty {
+	union := make([]graphProperty, 0)
+	for _, key := range keys {
+		for _, property := range properties[key] {
+			if !slices.ContainsFunc(union, func(p graphProperty) bool { return p.Name == property.Name }) {
+				union = append(union, property)
+			}
+		}
+	}
+
+	return union
+}
+
+// propertyValues maps the values of a node or an edge to the names of its
+// properties, leaving out missing values.
+func propertyValues(properties []graphProperty, values []any) map[string]string {
+	named := make(map[string]string)
+	for i, property := range properties {
+		if i >= len(values) {
+			break
+		}
+		if v, ok := graphValue(values[i]); ok {
+			named[property.Name] = fmt.Sprint(v)
+		}
+	}
+
+	return named
+}
+
+func xmlEscape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+// graphMLWriter streams a GraphML document, nodes and edges being written as
+// they come.
+type graphMLWriter struct {
+	file           *os.File
+	w              *bufio.Writer
+	nodeProperties []graphProperty
+	edgeProperties []graphProperty
+}
+
+func newGraphMLWriter(filename string) (*graphMLWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &graphMLWriter{
+		file:           file,
+		w:              bufio.NewWriter(file),
+		nodeProperties: graphPropertyUnion(nodeKinds, nodeProperties),
+		edgeProperties: graphPropertyUnion(edgeTypes, edgeProperties),
+	}
+
+	fmt.Fprint(w.w, xml.Header)
+	fmt.Fprintln(w.w, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://graphml.graphdrawing.org/xmlns http://graphml.graphdrawing.org/xmlns/1.0/graphml.xsd">`)
+	fmt.Fprintln(w.w, `  <key id="kind" for="node" attr.name="kind" attr.type="string"/>`)
+	for _, property := range w.nodeProperties {
+		fmt.Fprintf(w.w, "  <key id=\"n_%s\" for=\"node\" attr.name=\"%s\" attr.type=\"%s\"/>\n", property.Name, property.Name, property.Type)
+	}
+	fmt.Fprintln(w.w, `  <key id="type" for="edge" attr.name="type" attr.type="string"/>`)
+	for _, property := range w.edgeProperties {
+		fmt.Fprintf(w.w, "  <key id=\"e_%s\" for=\"edge\" attr.name=\"%s\" attr.type=\"%s\"/>\n", property.Name, property.Name, property.Type)
+	}
+	fmt.Fprintln(w.w, `  <graph id="discogs" edgedefault="directed">`)
+
+	return w, nil
+}
+
+func (w *graphMLWriter) WriteNode(node graphNode) error {
+	fmt.Fprintf(w.w, "    <node id=\"%s\"><data key=\"kind\">%s</data>", graphID(node.Kind, node.ID), node.Kind)
+	values := propertyValues(nodeProperties[node.Kind], node.Values)
+	for _, property := range w.nodeProperties {
+		if v, ok := values[property.Name]; ok {
+			fmt.Fprintf(w.w, "<data key=\"n_%s\">%s</data>", property.Name, xmlEscape(v))
+		}
+	}
+	_, err := fmt.Fprintln(w.w, "</node>")
+
+	return err
+}
+
+func (w *graphMLWriter) WriteEdge(edge graphEdge) error {
+	fmt.Fprintf(w.w, "    <edge source=\"%s\" target=\"%s\"><data key=\"type\">%s</data>", graphID(edge.FromKind, edge.From), graphID(edge.ToKind, edge.To), edge.Type)
+	values := propertyValues(edgeProperties[edge.Type], edge.Values)
+	for _, property := range w.edgeProperties {
+		if v, ok := values[property.Name]; ok {
+			fmt.Fprintf(w.w, "<data key=\"e_%s\">%s</data>", property.Name, xmlEscape(v))
+		}
+	}
+	_, err := fmt.Fprintln(w.w, "</edge>")
+
+	return err
+}
+
+func (w *graphMLWriter) Close() error {
+	fmt.Fprintln(w.w, "  </graph>")
+	fmt.Fprintln(w.w, "</graphml>")
+	if err := w.w.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// gexfWriter writes a GEXF document. GEXF lists all the nodes before the
+// edges, so edges are buffered in a temporary file until the nodes are
+// written.
+type gexfWriter struct {
+	file           *os.File
+	w              *bufio.Writer
+	edgesFile      *os.File
+	edges          *bufio.Writer
+	edgeCount      int64
+	nodeProperties []graphProperty
+	edgeProperties []graphProperty
+}
+
+func newGEXFWriter(filename string) (*gexfWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	edgesFile, err := os.CreateTemp("", "dgtools-gexf-edges-*")
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := &gexfWriter{
+		file:           file,
+		w:              bufio.NewWriter(file),
+		edgesFile:      edgesFile,
+		edges:          bufio.NewWriter(edgesFile),
+		nodeProperties: graphPropertyUnion(nodeKinds, nodeProperties),
+		edgeProperties: graphPropertyUnion(edgeTypes, edgeProperties),
+	}
+
+	fmt.Fprint(w.w, xml.Header)
+	fmt.Fprintln(w.w, `<gexf xmlns="http://gexf.net/1.3" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://gexf.net/1.3 http://gexf.net/1.3/gexf.xsd" version="1.3">`)
+	fmt.Fprintln(w.w, `  <graph defaultedgetype="directed" mode="static">`)
+	fmt.Fprintln(w.w, `    <attributes class="node">`)
+	fmt.Fprintln(w.w, `      <attribute id="kind" title="kind" type="string"/>`)
+	for _, property := range w.nodeProperties {
+		fmt.Fprintf(w.w, "      <attribute id=\"%s\" title=\"%s\" type=\"%s\"/>\n", property.Name, property.Name, gexfType(property.Type))
+	}
+	fmt.Fprintln(w.w, `    </attributes>`)
+	fmt.Fprintln(w.w, `    <attributes class="edge">`)
+	fmt.Fprintln(w.w, `      <attribute id="type" title="type" type="string"/>`)
+	for _, property := range w.edgeProperties {
+		fmt.Fprintf(w.w, "      <attribute id=\"%s\" title=\"%s\" type=\"%s\"/>\n", property.Name, property.Name, gexfType(property.Type))
+	}
+	fmt.Fprintln(w.w, `    </attributes>`)
+	fmt.Fprintln(w.w, `    <nodes>`)
+
+	return w, nil
+}
+
+func gexfType(t string) string {
+	if t == "int" {
+		return "integer"
+	}
+
+	return t
+}
+
+func (w *gexfWriter) WriteNode(node graphNode) error {
+	values := propertyValues(nodeProperties[node.Kind], node.Values)
+	// Nodes are labelled with their name or title.
+	label := values["name"]
+	if label == "" {
+		label = values["title"]
+	}
+
+	fmt.Fprintf(w.w, "      <node id=\"%s\" label=\"%s\"><attvalues><attvalue for=\"kind\" value=\"%s\"/>", graphID(node.Kind, node.ID), xmlEscape(label), node.Kind)
+	for _, property := range w.nodeProperties {
+		if v, ok := values[property.Name]; ok {
+			fmt.Fprintf(w.w, "<attvalue for=\"%s\" value=\"%s\"/>", property.Name, xmlEscape(v))
+		}
+	}
+	_, err := fmt.Fprintln(w.w, "</attvalues></node>")
+
+	return err
+}
+
+func (w *gexfWriter) WriteEdge(edge graphEdge) error {
+	fmt.Fprintf(w.edges, "      <edge id=\"%d\" source=\"%s\" target=\"%s\" label=\"%s\"><attvalues><attvalue for=\"type\" value=\"%s\"/>", w.edgeCount, graphID(edge.FromKind, edge.From), graphID(edge.ToKind, edge.To), edge.Type, edge.Type)
+	values := propertyValues(edgeProperties[edge.Type], edge.Values)
+	for _, property := range w.edgeProperties {
+		if v, ok := values[property.Name]; ok {
+			fmt.Fprintf(w.edges, "<attvalue for=\"%s\" value=\"%s\"/>", property.Name, xmlEscape(v))
+		}
+	}
+	_, err := fmt.Fprintln(w.edges, "</attvalues></edge>")
+	w.edgeCount++
+
+	return err
+}
+
+func (w *gexfWriter) Close() error {
+	defer os.Remove(w.edgesFile.Name())
+	defer w.edgesFile.Close()
+
+	fmt.Fprintln(w.w, `    </nodes>`)
+	fmt.Fprintln(w.w, `    <edges>`)
+	if err := w.edges.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if _, err := w.edgesFile.Seek(0, io.SeekStart); err != nil {
+		w.file.Close()
+		return err
+	}
+	if _, err := io.Copy(w.w, w.edgesFile); err != nil {
+		w.file.Close()
+		return err
+	}
+	fmt.Fprintln(w.w, `    </edges>`)
+	fmt.Fprintln(w.w, `  </graph>`)
+	fmt.Fprintln(w.w, `</gexf>`)
+
+	if err := w.w.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
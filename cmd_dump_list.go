@@ -1,27 +1,49 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"encoding/xml"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"net/http"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/lipgloss/table"
+	"github.com/dustin/go-humanize"
 	"github.com/marcw/dgtools/internal/discogs"
 	"github.com/urfave/cli/v3"
 )
 
 type dump struct {
-	Name         string
-	Size         int64
-	Type         string
-	Year         string
-	Month        string
-	LastModified time.Time
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	Type         string    `json:"type"`
+	Year         string    `json:"year"`
+	Month        string    `json:"month"`
+	LastModified time.Time `json:"last_modified"`
 }
 
+const (
+	ListOutputTable = "table"
+	ListOutputJSON  = "json"
+	ListOutputCSV   = "csv"
+)
+
+var listOutputs = []string{ListOutputTable, ListOutputJSON, ListOutputCSV}
+
+const (
+	ListSortDate = "date"
+	ListSortSize = "size"
+	ListSortType = "type"
+	ListSortName = "name"
+)
+
+var listSorts = []string{ListSortDate, ListSortSize, ListSortType, ListSortName}
+
 var discogsDumpListCmd = &cli.Command{
 	Name:  "list",
 	Usage: "List the files in the Discogs data dumps",
@@ -38,80 +60,246 @@ var discogsDumpListCmd = &cli.Command{
 			Name:  "type",
 			Usage: "Filter by data type",
 		},
+		&cli.BoolFlag{
+			Name:  "latest",
+			Usage: "Only list the latest month with the dumps of all the types and the CHECKSUM file published",
+		},
+		&cli.BoolFlag{
+			Name:  "months",
+			Usage: "List the months, telling which dumps are published and their total size",
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: fmt.Sprintf("The output format: %s", strings.Join(listOutputs, ", ")),
+			Value: ListOutputTable,
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if !slices.Contains(listOutputs, s) {
+					return fmt.Errorf("supported outputs are: %s", strings.Join(listOutputs, ", "))
+				}
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:  "sort",
+			Usage: fmt.Sprintf("Sort the files by %s", strings.Join(listSorts, ", ")),
+			Value: ListSortDate,
+			Action: func(ctx context.Context, cmd *cli.Command, s string) error {
+				if !slices.Contains(listSorts, s) {
+					return fmt.Errorf("supported sorts are: %s", strings.Join(listSorts, ", "))
+				}
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:  "reverse",
+			Usage: "Reverse the order of the files or months",
+		},
 		&cli.BoolFlag{
 			Name:  "no-table",
 			Usage: "Don't print the table",
 		},
 	},
 	Action: func(ctx context.Context, cmd *cli.Command) error {
-		discogsDataDumpsURL := cmd.String("discogs-bucket")
+		if cmd.Bool("months") && cmd.String("type") != "" {
+			return fmt.Errorf("--type can't be used with --months, which lists the dumps of all the types")
+		}
 
-		resp, err := http.Get(discogsDataDumpsURL)
+		// Listing the keys of a year is done by the bucket.
+		prefix := ""
+		if cmd.String("year") != "" {
+			prefix = yearPrefix(cmd.String("year"))
+		}
+		client, err := newBucketClient(cmd)
 		if err != nil {
 			return err
 		}
-		defer resp.Body.Close()
-
-		decoder := xml.NewDecoder(resp.Body)
-		var result listBucketResult
-		if err := decoder.Decode(&result); err != nil {
+		contents, err := newBucketLister(cmd, client).List(ctx, prefix)
+		if err != nil {
 			return err
 		}
 
-		dumps := make([]dump, 0)
+		months := bucketMonths(contents)
+		months = slices.DeleteFunc(months, func(m *bucketMonth) bool {
+			year, month, _ := strings.Cut(m.Month, "-")
+			return (cmd.String("year") != "" && year != cmd.String("year")) ||
+				(cmd.String("month") != "" && month != cmd.String("month"))
+		})
+		if cmd.Bool("latest") {
+			months = latestCompleteMonth(months)
+		}
+		if cmd.Bool("reverse") {
+			slices.Reverse(months)
+		}
 
-		for _, content := range result.Contents {
-			if !strings.HasSuffix(content.Key, ".xml.gz") {
-				continue
+		if cmd.Bool("months") {
+			summaries := make([]monthSummary, len(months))
+			for i, month := range months {
+				summaries[i] = summarizeMonth(month)
 			}
+			return printMonths(cmd.String("output"), summaries)
+		}
 
-			dumpFilename := discogs.DumpFilename(content.Key)
+		dumps := make([]dump, 0)
+		for _, month := range months {
+			for _, content := range month.Dumps {
+				dumpFilename := discogs.DumpFilename(content.Key)
+				if cmd.String("type") != "" && dumpFilename.Type() != cmd.String("type") {
+					continue
+				}
 
-			if cmd.String("year") != "" && dumpFilename.Year() != cmd.String("year") {
-				continue
-			}
-			if cmd.String("month") != "" && dumpFilename.Month() != cmd.String("month") {
-				continue
-			}
-			if cmd.String("type") != "" && dumpFilename.Type() != cmd.String("type") {
-				continue
+				dumps = append(dumps, dump{
+					Name:         content.Key,
+					Size:         content.Size,
+					LastModified: content.LastModified,
+					Type:         dumpFilename.Type(),
+					Year:         dumpFilename.Year(),
+					Month:        dumpFilename.Month(),
+				})
 			}
+		}
+		sortDumps(dumps, cmd.String("sort"))
+		if cmd.Bool("reverse") {
+			slices.Reverse(dumps)
+		}
 
-			d := dump{
-				Name:         content.Key,
-				Size:         content.Size,
-				LastModified: content.LastModified,
-				Type:         dumpFilename.Type(),
-				Year:         dumpFilename.Year(),
-				Month:        dumpFilename.Month(),
+		if cmd.Bool("no-table") {
+			for _, dump := range dumps {
+				fmt.Println(dump.Name)
 			}
+			return nil
+		}
 
-			dumps = append(dumps, d)
+		return printDumps(cmd.String("output"), dumps)
+	},
+}
+
+// latestCompleteMonth returns the latest of the months with all their files
+// published, or none.
+func latestCompleteMonth(months []*bucketMonth) []*bucketMonth {
+	for i := len(months) - 1; i >= 0; i-- {
+		if months[i].Complete() {
+			return months[i : i+1]
 		}
+	}
 
-		if !cmd.Bool("no-table") {
-			t := table.New().Headers("YEAR", "MONTH", "TYPE", "NAME", "SIZE")
-			for _, dump := range dumps {
-				t.Row(dump.Year, dump.Month, dump.Type, dump.Name, fmt.Sprintf("%d", dump.Size))
+	return nil
+}
+
+// sortDumps sorts dumps by one of listSorts. Ties are broken by date, then
+// by type.
+func sortDumps(dumps []dump, by string) {
+	slices.SortStableFunc(dumps, func(a, b dump) int {
+		byDate := cmp.Or(cmp.Compare(a.Year, b.Year), cmp.Compare(a.Month, b.Month), cmp.Compare(a.Type, b.Type))
+		switch by {
+		case ListSortSize:
+			return cmp.Or(cmp.Compare(a.Size, b.Size), byDate)
+		case ListSortType:
+			return cmp.Or(cmp.Compare(a.Type, b.Type), byDate)
+		case ListSortName:
+			return cmp.Compare(a.Name, b.Name)
+		default:
+			return byDate
+		}
+	})
+}
+
+func printDumps(output string, dumps []dump) error {
+	switch output {
+	case ListOutputJSON:
+		return printJSON(dumps)
+	case ListOutputCSV:
+		rows := make([][]string, len(dumps))
+		for i, d := range dumps {
+			rows[i] = []string{d.Year, d.Month, d.Type, d.Name, strconv.FormatInt(d.Size, 10), d.LastModified.Format(time.RFC3339)}
+		}
+		return printCSV([]string{"year", "month", "type", "name", "size", "last_modified"}, rows)
+	default:
+		t := table.New().Headers("YEAR", "MONTH", "TYPE", "NAME", "SIZE")
+		for _, d := range dumps {
+			t.Row(d.Year, d.Month, d.Type, d.Name, humanize.Bytes(uint64(d.Size)))
+		}
+		fmt.Println(t)
+		return nil
+	}
+}
+
+// monthSummary tells which files of the dumps of a month are published.
+type monthSummary struct {
+	Month    string   `json:"month"`
+	Types    []string `json:"types"`
+	Checksum bool     `json:"checksum"`
+	Complete bool     `json:"complete"`
+	// Size is the total size of the dumps.
+	Size int64 `json:"size"`
+}
+
+func summarizeMonth(m *bucketMonth) monthSummary {
+	summary := monthSummary{Month: m.Month, Types: make([]string, 0), Checksum: m.Checksum != nil, Complete: m.Complete()}
+	for _, dumpType := range discogs.DumpTypes {
+		if content, exists := m.Dumps[dumpType]; exists {
+			summary.Types = append(summary.Types, dumpType)
+			summary.Size += content.Size
+		}
+	}
+
+	return summary
+}
+
+func printMonths(output string, months []monthSummary) error {
+	switch output {
+	case ListOutputJSON:
+		return printJSON(months)
+	case ListOutputCSV:
+		rows := make([][]string, len(months))
+		for i, m := range months {
+			row := []string{m.Month}
+			for _, dumpType := range discogs.DumpTypes {
+				row = append(row, strconv.FormatBool(slices.Contains(m.Types, dumpType)))
 			}
-			fmt.Println(t)
-		} else {
-			for _, dump := range dumps {
-				fmt.Println(dump.Name)
+			rows[i] = append(row, strconv.FormatBool(m.Checksum), strconv.FormatBool(m.Complete), strconv.FormatInt(m.Size, 10))
+		}
+		return printCSV(append(append([]string{"month"}, discogs.DumpTypes...), "checksum", "complete", "size"), rows)
+	default:
+		headers := []string{"MONTH"}
+		for _, dumpType := range discogs.DumpTypes {
+			headers = append(headers, strings.ToUpper(dumpType))
+		}
+		t := table.New().Headers(append(headers, "CHECKSUM", "SIZE")...)
+		for _, m := range months {
+			row := []string{m.Month}
+			for _, dumpType := range discogs.DumpTypes {
+				row = append(row, presence(slices.Contains(m.Types, dumpType)))
 			}
+			t.Row(append(row, presence(m.Checksum), humanize.Bytes(uint64(m.Size)))...)
 		}
-
+		fmt.Println(t)
 		return nil
-	},
+	}
 }
 
-type listBucketResult struct {
-	Name     string                     `xml:"Name"`
-	Contents []listBucketResultContents `xml:"Contents"`
+func presence(present bool) string {
+	if present {
+		return "✓"
+	}
+
+	return "✗"
 }
 
-type listBucketResultContents struct {
-	Key          string    `xml:"Key"`
-	Size         int64     `xml:"Size"`
-	LastModified time.Time `xml:"LastModified"`
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
+
+func printCSV(header []string, rows [][]string) error {
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+
+	return w.Error()
 }
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/urfave/cli/v3"
+)
+
+// bucketLister lists the files of the bucket of the dumps, with as many
+// ListObjectsV2 requests as there are pages of keys. Listings are cached for
+// CacheTTL in CacheDir, so that commands run in a row list the bucket once.
+type bucketLister struct {
+	Client *bucketClient
+	// Bucket is the URL of the bucket, which listings are cached for.
+	Bucket   string
+	CacheTTL time.Duration
+	CacheDir string
+}
+
+// newBucketLister returns a lister of the bucket of client, caching listings
+// for --listing-cache-ttl.
+func newBucketLister(cmd *cli.Command, client *bucketClient) *bucketLister {
+	l := &bucketLister{Client: client, Bucket: client.Bucket(), CacheTTL: cmd.Duration("listing-cache-ttl")}
+	if dir, err := os.UserCacheDir(); err == nil {
+		l.CacheDir = filepath.Join(dir, "dgtools")
+	}
+
+	return l
+}
+
+// List lists the files whose key starts with prefix, such as data/2019/.
+func (l *bucketLister) List(ctx context.Context, prefix string) ([]listBucketResultContents, error) {
+	if contents, ok := l.cached(prefix); ok {
+		return contents, nil
+	}
+
+	contents := make([]listBucketResultContents, 0)
+	token := ""
+	// Continuation tokens are only valid for the bucket or the mirror that
+	// returned them, the pages after the first are requested from it only.
+	base := ""
+	for {
+		result, served, err := l.listPage(ctx, base, prefix, token)
+		if err != nil {
+			return nil, err
+		}
+		base = served
+		contents = append(contents, result.Contents...)
+		if !result.IsTruncated {
+			break
+		}
+		if result.NextContinuationToken == "" {
+			return nil, fmt.Errorf("the listing of %s is truncated without a continuation token", l.Bucket)
+		}
+		token = result.NextContinuationToken
+	}
+
+	l.cache(prefix, contents)
+
+	return contents, nil
+}
+
+// listPage requests a page of the listing, starting at the continuation
+// token of the previous page if any, from base or from the bucket and its
+// mirrors when base is empty. It returns the base that served the page.
+func (l *bucketLister) listPage(ctx context.Context, base, prefix, token string) (*listBucketResult, string, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+
+	bases := l.Client.URLs
+	if base != "" {
+		bases = []string{base}
+	}
+	resp, served, err := l.Client.getFrom(ctx, bases, "?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to list %s: %s", l.Bucket, resp.Status)
+	}
+
+	decoder := xml.NewDecoder(resp.Body)
+	var result listBucketResult
+	if err := decoder.Decode(&result); err != nil {
+		return nil, "", err
+	}
+
+	return &result, served, nil
+}
+
+// listingCache is the cache file of the listings of a bucket, keyed by
+// prefix.
+type listingCache struct {
+	Bucket   string                   `json:"bucket"`
+	Listings map[string]cachedListing `json:"listings"`
+}
+
+type cachedListing struct {
+	ListedAt time.Time                  `json:"listed_at"`
+	Contents []listBucketResultContents `json:"contents"`
+}
+
+func (l *bucketLister) cacheFile() string {
+	sum := sha256.Sum256([]byte(l.Bucket))
+
+	return filepath.Join(l.CacheDir, "listing-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+func (l *bucketLister) readCache() *listingCache {
+	c := &listingCache{Bucket: l.Bucket, Listings: make(map[string]cachedListing)}
+	data, err := os.ReadFile(l.cacheFile())
+	if err != nil {
+		return c
+	}
+	// A corrupted cache is ignored, and overwritten.
+	var read listingCache
+	if err := json.Unmarshal(data, &read); err != nil || read.Bucket != l.Bucket || read.Listings == nil {
+		return c
+	}
+
+	return &read
+}
+
+// cached returns the cached listing of prefix, filtered from the listing of
+// a shorter prefix if needed, and false if there's no fresh one.
+func (l *bucketLister) cached(prefix string) ([]listBucketResultContents, bool) {
+	if l.CacheTTL <= 0 || l.CacheDir == "" {
+		return nil, false
+	}
+
+	for cachedPrefix, listing := range l.readCache().Listings {
+		if !strings.HasPrefix(prefix, cachedPrefix) || time.Since(listing.ListedAt) > l.CacheTTL {
+			continue
+		}
+		contents := make([]listBucketResultContents, 0)
+		for _, content := range listing.Contents {
+			if strings.HasPrefix(content.Key, prefix) {
+				contents = append(contents, content)
+			}
+		}
+		return contents, true
+	}
+
+	return nil, false
+}
+
+// cache caches a listing. Failing to write the cache isn't an error, the
+// bucket is just listed again next time.
+func (l *bucketLister) cache(prefix string, contents []listBucketResultContents) {
+	if l.CacheTTL <= 0 || l.CacheDir == "" {
+		return
+	}
+
+	c := l.readCache()
+	for cachedPrefix, listing := range c.Listings {
+		if time.Since(listing.ListedAt) > l.CacheTTL {
+			delete(c.Listings, cachedPrefix)
+		}
+	}
+	c.Listings[prefix] = cachedListing{ListedAt: time.Now(), Contents: contents}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(l.CacheDir, 0o755); err != nil {
+		return
+	}
+	// The cache is replaced atomically as commands may run concurrently.
+	tmp, err := os.CreateTemp(l.CacheDir, "listing-*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || os.Rename(tmp.Name(), l.cacheFile()) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// yearPrefix returns the prefix of the keys of the dumps of a year, such as
+// data/2019/.
+func yearPrefix(year string) string {
+	return "data/" + year + "/"
+}
+
+// bucketMonth is the files of the dumps of a month in the bucket.
+type bucketMonth struct {
+	// Month is formatted as YYYY-MM.
+	Month    string
+	Dumps    map[string]listBucketResultContents
+	Checksum *listBucketResultContents
+}
+
+// Complete returns true if the dumps of all the types and their CHECKSUM
+// file are published.
+func (m *bucketMonth) Complete() bool {
+	return len(m.Missing()) == 0
+}
+
+// Missing returns the types of the dumps missing from the month, and
+// "CHECKSUM" if its CHECKSUM file is missing.
+func (m *bucketMonth) Missing() []string {
+	missing := make([]string, 0)
+	for _, dumpType := range discogs.DumpTypes {
+		if _, exists := m.Dumps[dumpType]; !exists {
+			missing = append(missing, dumpType)
+		}
+	}
+	if m.Checksum == nil {
+		missing = append(missing, "CHECKSUM")
+	}
+
+	return missing
+}
+
+// bucketMonths groups the files of the bucket by month, in chronological
+// order.
+func bucketMonths(contents []listBucketResultContents) []*bucketMonth {
+	months := make(map[string]*bucketMonth)
+	for _, content := range contents {
+		name := discogs.DumpFilename(path.Base(content.Key))
+		isChecksum := strings.HasSuffix(content.Key, "_CHECKSUM.txt")
+		if !isChecksum && (!strings.HasSuffix(content.Key, ".xml.gz") || !name.Valid()) {
+			continue
+		}
+		if isChecksum && name.ChecksumFilename() != name.String() {
+			continue
+		}
+
+		key := name.Year() + "-" + name.Month()
+		month, exists := months[key]
+		if !exists {
+			month = &bucketMonth{Month: key, Dumps: make(map[string]listBucketResultContents)}
+			months[key] = month
+		}
+		if isChecksum {
+			month.Checksum = &content
+		} else {
+			month.Dumps[name.Type()] = content
+		}
+	}
+
+	sorted := slices.Collect(maps.Values(months))
+	slices.SortFunc(sorted, func(a, b *bucketMonth) int {
+		return strings.Compare(a.Month, b.Month)
+	})
+
+	return sorted
+}
+
+// listBucketResult is a page of the result of a ListObjectsV2 request.
+type listBucketResult struct {
+	Name                  string                     `xml:"Name"`
+	IsTruncated           bool                       `xml:"IsTruncated"`
+	NextContinuationToken string                     `xml:"NextContinuationToken"`
+	Contents              []listBucketResultContents `xml:"Contents"`
+}
+
+type listBucketResultContents struct {
+	Key          string    `xml:"Key" json:"key"`
+	Size         int64     `xml:"Size" json:"size"`
+	LastModified time.Time `xml:"LastModified" json:"last_modified"`
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/urfave/cli/v3"
+)
+
+var discogsDumpSyncCmd = &cli.Command{
+	Name:  "sync",
+	Usage: "Mirror the dumps of the latest months of the bucket in a directory",
+	Description: "The dumps of the --keep-months latest months with the dumps of all the --types published are " +
+		"downloaded when they are missing from --dir or differ from the bucket by size or checksum, and verified. " +
+		"The dumps of older months are pruned from --dir.",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "dir",
+			Usage:    "The directory of the mirror",
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:  "keep-months",
+			Usage: "The number of months to keep in the mirror",
+			Value: 1,
+			Action: func(ctx context.Context, cmd *cli.Command, v int) error {
+				if v < 1 {
+					return fmt.Errorf("--keep-months must be at least 1")
+				}
+				return nil
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:  "types",
+			Usage: "The types of the dumps to mirror",
+			Value: discogs.DumpTypes,
+			Action: func(ctx context.Context, cmd *cli.Command, types []string) error {
+				for _, t := range types {
+					if !slices.Contains(discogs.DumpTypes, t) {
+						return fmt.Errorf("supported types are: %s", strings.Join(discogs.DumpTypes, ", "))
+					}
+				}
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Show what would be downloaded and pruned without changing anything",
+			Value: false,
+		},
+	}, downloadFlags()...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		dir := cmd.String("dir")
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("mirror directory does not exist: %s", dir)
+		}
+
+		options := defaultDownloadOptions
+		options.Retries = cmd.Int("retries")
+
+		client, err := newBucketClient(cmd)
+		if err != nil {
+			return err
+		}
+		catalog := commandCatalog(cmd, dir)
+		plan, err := planSync(ctx, newBucketLister(cmd, client), catalog, dir, cmd.Int("keep-months"), cmd.StringSlice("types"), options)
+		if err != nil {
+			return err
+		}
+		plan.print()
+
+		if cmd.Bool("dry-run") {
+			fmt.Println("Dry run, nothing was changed.")
+			return nil
+		}
+		for file, sum := range plan.Verified {
+			recordVerification(cmd, file, sum, true)
+		}
+
+		// Nothing is pruned unless the months kept are complete.
+		if err := downloadAll(ctx, cmd, client, plan.Downloads); err != nil {
+			return err
+		}
+		for _, file := range plan.Prunes {
+			if err := removeDump(cmd, file); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Downloaded %d files, pruned %d files.\n", len(plan.Downloads), len(plan.Prunes))
+
+		return nil
+	},
+}
+
+// syncPlan is what it takes to bring a mirror up to date.
+type syncPlan struct {
+	Months    []string
+	Downloads []downloadJob
+	// Reasons tells why each of the Downloads is needed.
+	Reasons  []string
+	Prunes   []string
+	UpToDate int
+	// Verified are the checksums of the dumps of the mirror hashed to
+	// compare them with the bucket and found up to date.
+	Verified map[string]string
+
+	catalog *dumpCatalog
+}
+
+// planSync compares the dumps of the keepMonths latest months of the bucket
+// with the mirror in dir, and lists the files of older months to prune. The
+// dumps verified before, as recorded in catalog, aren't hashed again.
+func planSync(ctx context.Context, lister *bucketLister, catalog *dumpCatalog, dir string, keepMonths int, types []string, options downloadOptions) (*syncPlan, error) {
+	contents, err := lister.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// The months kept are the latest ones with all the types published.
+	window := make([]*bucketMonth, 0, keepMonths)
+	months := bucketMonths(contents)
+	for i := len(months) - 1; i >= 0 && len(window) < keepMonths; i-- {
+		published := months[i].Checksum != nil
+		for _, t := range types {
+			if _, exists := months[i].Dumps[t]; !exists {
+				published = false
+			}
+		}
+		if published {
+			window = append(window, months[i])
+		}
+	}
+	if len(window) == 0 {
+		return nil, fmt.Errorf("no month has the dumps of %s published", strings.Join(types, ", "))
+	}
+	slices.Reverse(window)
+
+	plan := &syncPlan{Verified: make(map[string]string), catalog: catalog}
+	for _, month := range window {
+		plan.Months = append(plan.Months, month.Month)
+		if err := plan.compareMonth(ctx, lister.Client, dir, month, types, options); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := plan.prune(dir, window[0].Month, types); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// compareMonth plans the download of the files of a month missing from the
+// mirror or differing from the bucket.
+func (p *syncPlan) compareMonth(ctx context.Context, client *bucketClient, dir string, month *bucketMonth, types []string, options downloadOptions) error {
+	checksums, err := loadChecksums(ctx, client, month.Checksum.Key, true)
+	if err != nil {
+		return err
+	}
+
+	files := []listBucketResultContents{*month.Checksum}
+	for _, t := range types {
+		files = append(files, month.Dumps[t])
+	}
+
+	for i, content := range files {
+		name := path.Base(content.Key)
+		job := downloadJob{Key: content.Key, Dest: filepath.Join(dir, name), Options: options}
+		job.Options.Size = content.Size
+
+		// The CHECKSUM file itself is only compared by size.
+		if i > 0 {
+			sum, exists := checksums.Lookup(name)
+			if !exists {
+				return fmt.Errorf("%s is not listed in %s", name, path.Base(month.Checksum.Key))
+			}
+			job.Options.SHA256 = sum
+		}
+
+		reason, err := p.syncReason(job.Dest, content.Size, job.Options.SHA256)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			p.UpToDate++
+			continue
+		}
+		p.Downloads = append(p.Downloads, job)
+		p.Reasons = append(p.Reasons, reason)
+	}
+
+	return nil
+}
+
+// syncReason returns why a file of the mirror must be downloaded, or an
+// empty string if it's up to date.
+func (p *syncPlan) syncReason(filename string, size int64, sha256 string) (string, error) {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return "missing", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Size() != size {
+		return fmt.Sprintf("size %s instead of %s", humanize.Bytes(uint64(info.Size())), humanize.Bytes(uint64(size))), nil
+	}
+	if sha256 == "" || p.catalog.verified(info, sha256) {
+		return "", nil
+	}
+
+	actual, err := discogs.FileSHA256(filename)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(actual, sha256) {
+		return "checksum mismatch", nil
+	}
+	p.Verified[filename] = actual
+
+	return "", nil
+}
+
+// prune plans the removal of the dumps of the types synced, and of their
+// partial downloads, for the months of the mirror older than oldest. The
+// CHECKSUM file of such a month is removed along with its last dump.
+func (p *syncPlan) prune(dir, oldest string, types []string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	checksums := make(map[string]string)
+	kept := make(map[string]bool)
+	for _, entry := range entries {
+		name := discogs.DumpFilename(entry.Name())
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "discogs_") {
+			continue
+		}
+		base := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), ".etag"), ".part")
+		isChecksum := base == name.ChecksumFilename() && strings.HasSuffix(base, "_CHECKSUM.txt")
+		if !isChecksum && (!strings.HasSuffix(base, ".xml.gz") || !name.Valid()) {
+			continue
+		}
+
+		month := name.Year() + "-" + name.Month()
+		if month >= oldest {
+			continue
+		}
+		switch {
+		case isChecksum && base == entry.Name():
+			checksums[month] = filepath.Join(dir, entry.Name())
+		case isChecksum, slices.Contains(types, name.Type()):
+			p.Prunes = append(p.Prunes, filepath.Join(dir, entry.Name()))
+		case base == entry.Name():
+			kept[month] = true
+		}
+	}
+
+	for month, checksum := range checksums {
+		if !kept[month] {
+			p.Prunes = append(p.Prunes, checksum)
+		}
+	}
+	slices.Sort(p.Prunes)
+
+	return nil
+}
+
+func (p *syncPlan) print() {
+	fmt.Printf("Months kept: %s\n", strings.Join(p.Months, ", "))
+
+	var size int64
+	for i, job := range p.Downloads {
+		size += job.Options.Size
+		fmt.Printf("download %s (%s)\n", path.Base(job.Key), p.Reasons[i])
+	}
+	for _, file := range p.Prunes {
+		fmt.Printf("prune    %s\n", filepath.Base(file))
+	}
+
+	fmt.Printf("%d files to download (%s), %d files to prune, %d files up to date.\n",
+		len(p.Downloads), humanize.Bytes(uint64(size)), len(p.Prunes), p.UpToDate)
+}
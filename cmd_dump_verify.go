@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marcw/dgtools/internal/discogs"
+	"github.com/urfave/cli/v3"
+)
+
+var discogsDumpVerifyCmd = &cli.Command{
+	Name:  "verify",
+	Usage: "Verify the checksum of downloaded Discogs data dumps",
+	Description: "The checksums are read from the CHECKSUM file of the month of each dump when it's next to it, " +
+		"and fetched from the bucket otherwise.",
+	Arguments: []cli.Argument{
+		&cli.StringArgs{
+			Name:      "files",
+			UsageText: "The dump files to verify",
+			Min:       1,
+			Max:       -1,
+		},
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "checksum-file",
+			Usage: "Read the checksums from this CHECKSUM file instead",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		files := cmd.StringArgs("files")
+		if len(files) == 0 {
+			return fmt.Errorf("at least one dump file is required")
+		}
+
+		client, err := newBucketClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		// CHECKSUM files are read once for all the dumps of a month.
+		checksums := make(map[string]discogs.Checksums)
+		failed := 0
+		for _, file := range files {
+			source, remote, err := checksumSource(file, cmd.String("checksum-file"))
+			if err != nil {
+				fmt.Printf("%s: ERROR %v\n", file, err)
+				failed++
+				continue
+			}
+			if _, exists := checksums[source]; !exists {
+				checksums[source], err = loadChecksums(ctx, client, source, remote)
+				if err != nil {
+					return err
+				}
+			}
+
+			expected, exists := checksums[source].Lookup(file)
+			if !exists {
+				fmt.Printf("%s: ERROR not listed in %s\n", file, source)
+				failed++
+				continue
+			}
+			actual, err := discogs.FileSHA256(file)
+			if err != nil {
+				fmt.Printf("%s: ERROR %v\n", file, err)
+				failed++
+				continue
+			}
+			matches := strings.EqualFold(actual, expected)
+			recordVerification(cmd, file, actual, matches)
+			if !matches {
+				fmt.Printf("%s: FAILED expected %s, got %s\n", file, expected, actual)
+				failed++
+				continue
+			}
+			fmt.Printf("%s: OK\n", file)
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d files failed verification", failed, len(files))
+		}
+
+		return nil
+	},
+}
+
+// checksumSource returns where to read the checksum of a dump file from: the
+// CHECKSUM file given, the CHECKSUM file of its month next to it, or else
+// the key of that file in the bucket, in which case remote is true.
+func checksumSource(file, checksumFile string) (source string, remote bool, err error) {
+	if checksumFile != "" {
+		return checksumFile, false, nil
+	}
+
+	fn := discogs.DumpFilename(filepath.Base(file))
+	if !fn.Valid() {
+		return "", false, fmt.Errorf("cannot tell the month of %s", file)
+	}
+	local := filepath.Join(filepath.Dir(file), fn.ChecksumFilename())
+	if _, err := os.Stat(local); err == nil {
+		return local, false, nil
+	}
+
+	return discogs.DumpFilename(fn.ChecksumFilename()).Key(), true, nil
+}
+
+// loadChecksums reads a CHECKSUM file from the disk, or from the bucket when
+// it's remote.
+func loadChecksums(ctx context.Context, client *bucketClient, source string, remote bool) (discogs.Checksums, error) {
+	if !remote {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return discogs.ParseChecksums(file)
+	}
+
+	resp, err := client.Get(ctx, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", source, resp.Status)
+	}
+
+	return discogs.ParseChecksums(resp.Body)
+}
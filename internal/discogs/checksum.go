@@ -0,0 +1,65 @@
+package discogs
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileSHA256 returns the hex-encoded SHA-256 checksum of a file, as listed in
+// the CHECKSUM files published alongside the dumps.
+func FileSHA256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Checksums maps the names of the files of a monthly dump to their
+// hex-encoded SHA-256 checksum, as listed in its CHECKSUM file, lowercased.
+type Checksums map[string]string
+
+// ParseChecksums parses a CHECKSUM file, in the format of sha256sum.
+func ParseChecksums(r io.Reader) (Checksums, error) {
+	checksums := make(Checksums)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid checksum on line %d: %q", line, scanner.Text())
+		}
+		if _, err := hex.DecodeString(fields[0]); err != nil {
+			return nil, fmt.Errorf("invalid checksum on line %d: %q", line, scanner.Text())
+		}
+		// Files hashed in binary mode are marked with an asterisk.
+		checksums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return checksums, nil
+}
+
+// Lookup returns the checksum listed for a file, whatever its directory.
+func (c Checksums) Lookup(filename string) (string, bool) {
+	checksum, exists := c[filepath.Base(filename)]
+
+	return checksum, exists
+}
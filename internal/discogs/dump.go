@@ -1,16 +1,25 @@
 package discogs
 
 import (
+	"bytes"
 	"compress/gzip"
 	"encoding/xml"
+	"fmt"
 	"io"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 )
 
+// DumpTypes are the types of entities a monthly dump is made of.
+var DumpTypes = []string{"artists", "labels", "masters", "releases"}
+
 var typeExtractor = regexp.MustCompile(`(artists|releases|masters|labels)`)
 var dateExtractor = regexp.MustCompile(`discogs_(\d{4})(\d{2})`)
+var prefixExtractor = regexp.MustCompile(`discogs_\d{8}`)
 
 type DumpFilename string
 
@@ -18,6 +27,12 @@ func (fn DumpFilename) String() string {
 	return string(fn)
 }
 
+// Valid returns true if the name carries the date and the type of a dump.
+// Year, Month and Type panic on invalid names.
+func (fn DumpFilename) Valid() bool {
+	return dateExtractor.MatchString(string(fn)) && typeExtractor.MatchString(string(fn))
+}
+
 func (fn DumpFilename) Gzipped() bool {
 	return strings.HasSuffix(string(fn), ".gz")
 }
@@ -34,6 +49,18 @@ func (fn DumpFilename) Type() string {
 	return typeExtractor.FindStringSubmatch(string(fn))[1]
 }
 
+// ChecksumFilename returns the name of the CHECKSUM file listing the
+// checksum of the dump, such as discogs_20250901_CHECKSUM.txt.
+func (fn DumpFilename) ChecksumFilename() string {
+	return prefixExtractor.FindString(string(fn)) + "_CHECKSUM.txt"
+}
+
+// Key returns the key of the file in the bucket of the dumps, such as
+// data/2025/discogs_20250901_artists.xml.gz.
+func (fn DumpFilename) Key() string {
+	return path.Join("data", fn.Year(), path.Base(filepath.ToSlash(string(fn))))
+}
+
 // Dump is a wrapper around a discogs dump file.
 // Dump implements the io.ReadCloser interface.
 type Dump struct {
@@ -42,37 +69,51 @@ type Dump struct {
 
 	file     *os.File
 	gzReader *gzip.Reader
+	skip     []string
 }
 
 // OpenDiscogsDump creates a new DiscogsDump.
 func OpenDumpFile(filename string) (*Dump, error) {
-	dd := &Dump{}
-
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	dd.file = file
-	dd.reader = file
-
-	if !strings.HasSuffix(filename, ".gz") {
-		return dd, nil
-	}
-
-	gz, err := gzip.NewReader(file)
+	dd, err := NewDump(file, strings.HasSuffix(filename, ".gz"))
 	if err != nil {
-		dd.file.Close()
+		file.Close()
 		return nil, err
 	}
-	dd.gzReader = gz
-	dd.reader = gz
+	dd.file = file
+
+	return dd, nil
+}
+
+// NewDump reads a dump from r, such as the body of a response, gunzipping
+// it if gzipped. Closing the dump doesn't close r.
+func NewDump(r io.Reader, gzipped bool) (*Dump, error) {
+	dd := &Dump{reader: r}
 
+	if gzipped {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		dd.gzReader = gz
+		dd.reader = gz
+	}
 	dd.Decoder = xml.NewDecoder(dd.reader)
 
 	return dd, nil
 }
 
+// SkipElements makes DecodeNextElement skip the children elements of the
+// decoded entities with the given names, which saves decoding subtrees that
+// are not needed.
+func (dd *Dump) SkipElements(names ...string) {
+	dd.skip = names
+}
+
 func (dd *Dump) DecodeNextElement() (any, error) {
 	t, err := dd.Decoder.Token()
 	if err == io.EOF {
@@ -82,41 +123,114 @@ func (dd *Dump) DecodeNextElement() (any, error) {
 		return nil, err
 	}
 
-	switch se := t.(type) {
-	case xml.StartElement:
-		inElement := se.Name.Local
-		switch inElement {
-		case "artist":
-			artist := &Artist{}
-			if err := dd.Decoder.DecodeElement(artist, &se); err != nil {
-				return nil, err
-			}
-			return artist, nil
-		case "label":
-			label := &Label{}
-			if err := dd.Decoder.DecodeElement(label, &se); err != nil {
-				return nil, err
-			}
-			return label, nil
-		case "master":
-			master := &Master{}
-			if err := dd.Decoder.DecodeElement(master, &se); err != nil {
-				return nil, err
-			}
-			return master, nil
-		case "release":
-			release := &Release{}
-			if err := dd.Decoder.DecodeElement(release, &se); err != nil {
-				return nil, err
-			}
+	if se, ok := t.(xml.StartElement); ok {
+		return decodeEntity(dd.Decoder, se, dd.skip)
+	}
 
-			return release, nil
+	return nil, nil
+}
+
+// DecodeRawElement decodes an entity from its raw XML, as returned by
+// ElementSplitter.Next, skipping the children elements named in skip.
+// It returns nil if the XML is not a Discogs entity.
+func DecodeRawElement(raw []byte, skip []string) (any, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(raw))
+	t, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	se, ok := t.(xml.StartElement)
+	if !ok {
+		return nil, fmt.Errorf("expected a start element, got %T", t)
+	}
+
+	return decodeEntity(decoder, se, skip)
+}
+
+// decodeEntity decodes the entity starting with se.
+func decodeEntity(d *xml.Decoder, se xml.StartElement, skip []string) (any, error) {
+	decoder := d
+	if len(skip) > 0 {
+		decoder = xml.NewTokenDecoder(&skippingTokenReader{decoder: d, start: &se, skip: skip})
+		// The new decoder has to go through the start element before
+		// decoding the entity.
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
 		}
 	}
 
+	switch se.Name.Local {
+	case "artist":
+		artist := &Artist{}
+		if err := decoder.DecodeElement(artist, &se); err != nil {
+			return nil, err
+		}
+		return artist, nil
+	case "label":
+		label := &Label{}
+		if err := decoder.DecodeElement(label, &se); err != nil {
+			return nil, err
+		}
+		return label, nil
+	case "master":
+		master := &Master{}
+		if err := decoder.DecodeElement(master, &se); err != nil {
+			return nil, err
+		}
+		return master, nil
+	case "release":
+		release := &Release{}
+		if err := decoder.DecodeElement(release, &se); err != nil {
+			return nil, err
+		}
+
+		return release, nil
+	}
+
 	return nil, nil
 }
 
+// skippingTokenReader replays the start element of an entity and then drops
+// the subtrees of its children elements that are in skip. It must be created
+// right after reading the start element of the entity.
+type skippingTokenReader struct {
+	decoder *xml.Decoder
+	start   *xml.StartElement
+	skip    []string
+	depth   int
+}
+
+func (r *skippingTokenReader) Token() (xml.Token, error) {
+	if r.start != nil {
+		start := *r.start
+		r.start = nil
+		return start, nil
+	}
+
+	for {
+		t, err := r.decoder.Token()
+		if err != nil {
+			return t, err
+		}
+
+		switch se := t.(type) {
+		case xml.StartElement:
+			if r.depth == 0 && slices.Contains(r.skip, se.Name.Local) {
+				if err := r.decoder.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			r.depth++
+		case xml.EndElement:
+			r.depth--
+		}
+
+		return t, nil
+	}
+}
+
 // Close closes the dump file.
 func (dd *Dump) Close() error {
 	if dd.gzReader != nil {
@@ -124,6 +238,9 @@ func (dd *Dump) Close() error {
 			return err
 		}
 	}
+	if dd.file == nil {
+		return nil
+	}
 
 	return dd.file.Close()
 }
@@ -131,3 +248,9 @@ func (dd *Dump) Close() error {
 func (dd *Dump) Read(p []byte) (n int, err error) {
 	return dd.reader.Read(p)
 }
+
+// Splitter returns an ElementSplitter over the dump. It must not be used
+// along with DecodeNextElement.
+func (dd *Dump) Splitter() *ElementSplitter {
+	return NewElementSplitter(dd.reader)
+}
@@ -21,6 +21,10 @@ const (
 	ModeReleasesArtists
 	ModeReleasesExtraArtists
 	ModeReleasesLabels
+	ModeArtistsMentions
+	ModeLabelsMentions
+	ModeMastersMentions
+	ModeReleasesMentions
 )
 
 var Modes = []int{
@@ -34,6 +38,10 @@ var Modes = []int{
 	ModeReleasesArtists,
 	ModeReleasesExtraArtists,
 	ModeReleasesLabels,
+	ModeArtistsMentions,
+	ModeLabelsMentions,
+	ModeMastersMentions,
+	ModeReleasesMentions,
 }
 
 var Tables = map[int]pgx.Identifier{
@@ -47,9 +55,19 @@ var Tables = map[int]pgx.Identifier{
 	ModeReleasesArtists:      pgx.Identifier{"discogs_release_artists"},
 	ModeReleasesExtraArtists: pgx.Identifier{"discogs_release_extra_artists"},
 	ModeReleasesLabels:       pgx.Identifier{"discogs_release_labels"},
+	ModeArtistsMentions:      pgx.Identifier{"discogs_artist_mentions"},
+	ModeLabelsMentions:       pgx.Identifier{"discogs_label_mentions"},
+	ModeMastersMentions:      pgx.Identifier{"discogs_master_mentions"},
+	ModeReleasesMentions:     pgx.Identifier{"discogs_release_mentions"},
 }
 
 type CopyFromDump struct {
+	// Normalizer fills the parsed fields of the entities when not nil.
+	Normalizer *Normalizer
+	// Markup is the format the markup of the entities is rendered to, kept
+	// as is when empty.
+	Markup string
+
 	mode    int
 	dd      *Dump
 	decoder *xml.Decoder
@@ -83,8 +101,44 @@ func (ds *CopyFromDump) Table() pgx.Identifier {
 	return Tables[ds.mode]
 }
 
+// Columns returns the columns the data is copied to, see ImportColumns.
 func (ds *CopyFromDump) Columns() []string {
-	switch ds.mode {
+	return ImportColumns(ds.mode, ds.Normalizer != nil)
+}
+
+// normalizedColumns are the columns filled by a Normalizer. They are the last
+// columns of the records of their tables.
+var normalizedColumns = []string{"released_date", "released_precision", "display_name", "disambiguation", "sort_name"}
+
+// ImportColumns returns the columns of the table of a mode. The columns filled
+// by a Normalizer are left out unless normalized, so that imports without one
+// work on databases without them.
+func ImportColumns(mode int, normalized bool) []string {
+	columns := modeColumns(mode)
+	if normalized {
+		return columns
+	}
+	for i, column := range columns {
+		if slices.Contains(normalizedColumns, column) {
+			return columns[:i]
+		}
+	}
+
+	return columns
+}
+
+// importRecord trims the columns filled by a Normalizer off a record of a
+// mode, unless normalized.
+func importRecord(record []any, mode int, normalized bool) []any {
+	if normalized {
+		return record
+	}
+
+	return record[:len(ImportColumns(mode, false))]
+}
+
+func modeColumns(mode int) []string {
+	switch mode {
 	case ModeArtists:
 		return Artist{}.Columns()
 	case ModeArtistsAliases:
@@ -105,6 +159,14 @@ func (ds *CopyFromDump) Columns() []string {
 		return Release{}.ExtraArtistsColumns()
 	case ModeReleasesLabels:
 		return Release{}.LabelsColumns()
+	case ModeArtistsMentions:
+		return Artist{}.MentionsColumns()
+	case ModeLabelsMentions:
+		return Label{}.MentionsColumns()
+	case ModeMastersMentions:
+		return Master{}.MentionsColumns()
+	case ModeReleasesMentions:
+		return Release{}.MentionsColumns()
 	default:
 		return nil
 	}
@@ -144,6 +206,15 @@ func (ds *CopyFromDump) Next() bool {
 		return false
 	}
 	ds.err = nil
+	if ds.Normalizer != nil {
+		ds.Normalizer.Normalize(decodedElement)
+	}
+	if ds.Markup != "" {
+		if err := RenderMarkup(decodedElement, ds.Markup); err != nil {
+			ds.err = err
+			return false
+		}
+	}
 
 	switch ds.mode {
 	case ModeArtists:
@@ -166,6 +237,14 @@ func (ds *CopyFromDump) Next() bool {
 		ds.records = decodedElement.(*Release).ToExtraArtistsRecords()
 	case ModeReleasesLabels:
 		ds.records = decodedElement.(*Release).ToLabelsRecords()
+	case ModeArtistsMentions:
+		ds.records = decodedElement.(*Artist).ToMentionsRecords()
+	case ModeLabelsMentions:
+		ds.records = decodedElement.(*Label).ToMentionsRecords()
+	case ModeMastersMentions:
+		ds.records = decodedElement.(*Master).ToMentionsRecords()
+	case ModeReleasesMentions:
+		ds.records = decodedElement.(*Release).ToMentionsRecords()
 	}
 
 	if len(ds.records) == 0 {
@@ -179,6 +258,7 @@ func (ds *CopyFromDump) Next() bool {
 // See pgx.CopyFromSource interface for more details.
 func (ds *CopyFromDump) Values() (value []any, err error) {
 	value, ds.records = ds.records[0], ds.records[1:]
+	value = importRecord(value, ds.mode, ds.Normalizer != nil)
 
 	return
 }
@@ -226,24 +306,34 @@ func (c *CopyFromRecordChannel) Err() error {
 
 // MultiTableXMLParser parses XML once and distributes records to multiple channels
 type MultiTableXMLParser struct {
+	// Normalizer fills the parsed fields of the entities when not nil.
+	Normalizer *Normalizer
+	// Markup is the format the markup of the entities is rendered to, kept
+	// as is when empty.
+	Markup string
+
 	channels map[int]chan []any
 	wg       *sync.WaitGroup
 	dd       *Dump
 }
 
 func NewMultiTableXMLParser(filename string, channelMap map[int]chan []any, wg *sync.WaitGroup) (*MultiTableXMLParser, error) {
-	parser := &MultiTableXMLParser{
-		channels: channelMap,
-		wg:       wg,
-	}
-
 	dd, err := OpenDumpFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	parser.dd = dd
-	return parser, nil
+	return NewMultiTableXMLParserFromDump(dd, channelMap, wg), nil
+}
+
+// NewMultiTableXMLParserFromDump creates a parser of a dump already opened,
+// such as one streamed with NewDump. Closing the parser closes the dump.
+func NewMultiTableXMLParserFromDump(dd *Dump, channelMap map[int]chan []any, wg *sync.WaitGroup) *MultiTableXMLParser {
+	return &MultiTableXMLParser{
+		channels: channelMap,
+		wg:       wg,
+		dd:       dd,
+	}
 }
 
 func (p *MultiTableXMLParser) Close() error {
@@ -269,6 +359,16 @@ func (p *MultiTableXMLParser) ParseAndDistribute() error {
 		if element == nil {
 			continue
 		}
+		if p.Normalizer != nil {
+			p.Normalizer.Normalize(element)
+		}
+		// Mentions are extracted from the markup before it's rendered.
+		mentionsMode, mentions := p.mentionsRecords(element)
+		if p.Markup != "" {
+			if err := RenderMarkup(element, p.Markup); err != nil {
+				return err
+			}
+		}
 
 		switch e := element.(type) {
 		case *Artist:
@@ -280,59 +380,86 @@ func (p *MultiTableXMLParser) ParseAndDistribute() error {
 		case *Release:
 			p.distributeReleaseRecords(e)
 		}
+		for _, record := range mentions {
+			p.channels[mentionsMode] <- record
+		}
 	}
 }
 
+// mentionsRecords returns the mode of the mentions table of an element, and
+// its records if the table is imported.
+func (p *MultiTableXMLParser) mentionsRecords(element any) (int, [][]any) {
+	var mode int
+	var records func() [][]any
+	switch e := element.(type) {
+	case *Artist:
+		mode, records = ModeArtistsMentions, e.ToMentionsRecords
+	case *Label:
+		mode, records = ModeLabelsMentions, e.ToMentionsRecords
+	case *Master:
+		mode, records = ModeMastersMentions, e.ToMentionsRecords
+	case *Release:
+		mode, records = ModeReleasesMentions, e.ToMentionsRecords
+	default:
+		return 0, nil
+	}
+	if _, exists := p.channels[mode]; !exists {
+		return mode, nil
+	}
+
+	return mode, records()
+}
+
 func (p *MultiTableXMLParser) distributeArtistRecords(artist *Artist) {
 	if ch, exists := p.channels[ModeArtists]; exists {
-		ch <- artist.ToRecord()
+		ch <- importRecord(artist.ToRecord(), ModeArtists, p.Normalizer != nil)
 	}
 	if ch, exists := p.channels[ModeArtistsAliases]; exists {
 		for _, record := range artist.ToAliasesRecords() {
-			ch <- record
+			ch <- importRecord(record, ModeArtistsAliases, p.Normalizer != nil)
 		}
 	}
 	if ch, exists := p.channels[ModeArtistsMemberships]; exists {
 		for _, record := range artist.ToMembershipsRecords() {
-			ch <- record
+			ch <- importRecord(record, ModeArtistsMemberships, p.Normalizer != nil)
 		}
 	}
 }
 
 func (p *MultiTableXMLParser) distributeLabelRecords(label *Label) {
 	if ch, exists := p.channels[ModeLabels]; exists {
-		ch <- label.ToRecord()
+		ch <- importRecord(label.ToRecord(), ModeLabels, p.Normalizer != nil)
 	}
 }
 
 func (p *MultiTableXMLParser) distributeMasterRecords(master *Master) {
 	if ch, exists := p.channels[ModeMasters]; exists {
-		ch <- master.ToRecord()
+		ch <- importRecord(master.ToRecord(), ModeMasters, p.Normalizer != nil)
 	}
 	if ch, exists := p.channels[ModeMastersArtists]; exists {
 		for _, record := range master.ToArtistsRecords() {
-			ch <- record
+			ch <- importRecord(record, ModeMastersArtists, p.Normalizer != nil)
 		}
 	}
 }
 
 func (p *MultiTableXMLParser) distributeReleaseRecords(release *Release) {
 	if ch, exists := p.channels[ModeReleases]; exists {
-		ch <- release.ToRecord()
+		ch <- importRecord(release.ToRecord(), ModeReleases, p.Normalizer != nil)
 	}
 	if ch, exists := p.channels[ModeReleasesArtists]; exists {
 		for _, record := range release.ToArtistsRecords() {
-			ch <- record
+			ch <- importRecord(record, ModeReleasesArtists, p.Normalizer != nil)
 		}
 	}
 	if ch, exists := p.channels[ModeReleasesExtraArtists]; exists {
 		for _, record := range release.ToExtraArtistsRecords() {
-			ch <- record
+			ch <- importRecord(record, ModeReleasesExtraArtists, p.Normalizer != nil)
 		}
 	}
 	if ch, exists := p.channels[ModeReleasesLabels]; exists {
 		for _, record := range release.ToLabelsRecords() {
-			ch <- record
+			ch <- importRecord(record, ModeReleasesLabels, p.Normalizer != nil)
 		}
 	}
 }
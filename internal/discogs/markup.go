@@ -0,0 +1,116 @@
+package discogs
+
+import (
+	"github.com/marcw/dgtools/internal/discogs/markup"
+)
+
+// Mention is a reference, in the markup of an entity, to another entity: an
+// edge from the entity to the artist, label, release or master it mentions.
+type Mention struct {
+	SourceID   int64  `json:"source_id" parquet:"source_id"`
+	Field      string `json:"field" parquet:"field,dict"`
+	TargetType string `json:"target_type" parquet:"target_type,dict"`
+	TargetID   int64  `json:"target_id" parquet:"target_id"`
+}
+
+// markupField returns the ID of an element, the JSON name of its field
+// holding markup, and a pointer to the field.
+func markupField(element any) (int64, string, **string) {
+	switch e := element.(type) {
+	case *Artist:
+		return e.ID, "profile", &e.Profile
+	case *Label:
+		return e.ID, "profile", &e.Profile
+	case *Master:
+		return e.ID, "notes", &e.Notes
+	case *Release:
+		return e.ID, "notes", &e.Notes
+	default:
+		return 0, "", nil
+	}
+}
+
+// MarkupElement returns the XML element of an element holding markup, named
+// like its field.
+func MarkupElement(element any) string {
+	_, field, _ := markupField(element)
+
+	return field
+}
+
+// Mentions returns the entities referenced by ID in the markup of an
+// element, the profile of artists and labels or the notes of masters and
+// releases.
+func Mentions(element any) []Mention {
+	id, field, value := markupField(element)
+	if value == nil || *value == nil {
+		return nil
+	}
+
+	references := markup.Parse(**value).References()
+	mentions := make([]Mention, len(references))
+	for i, reference := range references {
+		mentions[i] = Mention{SourceID: id, Field: field, TargetType: reference.Kind, TargetID: reference.ID}
+	}
+
+	return mentions
+}
+
+// RenderMarkup replaces the markup of an element with its rendering in one
+// of markup.Formats.
+func RenderMarkup(element any, format string) error {
+	_, _, value := markupField(element)
+	if value == nil || *value == nil {
+		return nil
+	}
+
+	rendered, err := markup.Parse(**value).Render(format)
+	if err != nil {
+		return err
+	}
+	*value = &rendered
+
+	return nil
+}
+
+func (a Artist) MentionsColumns() []string {
+	return []string{"artist_id", "mentioned_type", "mentioned_id"}
+}
+
+func (l Label) MentionsColumns() []string {
+	return []string{"label_id", "mentioned_type", "mentioned_id"}
+}
+
+func (m Master) MentionsColumns() []string {
+	return []string{"master_id", "mentioned_type", "mentioned_id"}
+}
+
+func (r Release) MentionsColumns() []string {
+	return []string{"release_id", "mentioned_type", "mentioned_id"}
+}
+
+func (a *Artist) ToMentionsRecords() [][]any {
+	return mentionsRecords(a)
+}
+
+func (l *Label) ToMentionsRecords() [][]any {
+	return mentionsRecords(l)
+}
+
+func (m *Master) ToMentionsRecords() [][]any {
+	return mentionsRecords(m)
+}
+
+func (r *Release) ToMentionsRecords() [][]any {
+	return mentionsRecords(r)
+}
+
+func mentionsRecords(element any) [][]any {
+	mentions := Mentions(element)
+	records := make([][]any, len(mentions))
+	for i, mention := range mentions {
+		records[i] = []any{mention.SourceID, mention.TargetType, mention.TargetID}
+	}
+
+	return records
+}
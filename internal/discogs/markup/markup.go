@@ -0,0 +1,281 @@
+// Package markup parses the markup of the free-text fields of Discogs, such
+// as the profiles of artists and labels and the notes of releases, and
+// renders it as plain text, Markdown or HTML.
+//
+// The markup looks like BBCode: [b]bold[/b], [i]italic[/i], [u]underlined[/u]
+// and [s]struck[/s] text, [url=https://example.com]links[/url] and
+// references to other entities, by ID such as [a12345], [l123], [r123] and
+// [m456], or by name such as [a=Artist Name] and [l=Label Name]. Anything
+// else between brackets is kept verbatim.
+package markup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kinds of the entities referenced by the markup.
+const (
+	KindArtist  = "artist"
+	KindLabel   = "label"
+	KindRelease = "release"
+	KindMaster  = "master"
+)
+
+// referenceKinds maps the tags of references to the kind of entity they
+// reference.
+var referenceKinds = map[byte]string{
+	'a': KindArtist,
+	'l': KindLabel,
+	'r': KindRelease,
+	'm': KindMaster,
+}
+
+// styleTags are the tags changing the style of the text they enclose.
+var styleTags = []string{"b", "i", "u", "s"}
+
+// Node is a node of a parsed Document: Text, *Style, *Link or Reference.
+type Node interface {
+	node()
+}
+
+// Text is text without markup.
+type Text string
+
+// Style is text in bold (b), italic (i), underlined (u) or struck (s).
+type Style struct {
+	Tag      string
+	Children []Node
+}
+
+// Link is a link to an URL.
+type Link struct {
+	URL      string
+	Children []Node
+}
+
+// Reference is a reference to another entity of Discogs, by ID or by name.
+type Reference struct {
+	Kind string
+	ID   int64
+	Name string
+}
+
+func (Text) node()      {}
+func (*Style) node()    {}
+func (*Link) node()     {}
+func (Reference) node() {}
+
+// URL returns the page of the referenced entity on Discogs, or an empty
+// string if it's referenced by name.
+func (r Reference) URL() string {
+	if r.ID == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("https://www.discogs.com/%s/%d", r.Kind, r.ID)
+}
+
+// Label returns the text a reference is rendered as: the name of the entity,
+// or its kind and ID when it's referenced by ID.
+func (r Reference) Label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	return fmt.Sprintf("%s %d", r.Kind, r.ID)
+}
+
+// Document is parsed markup.
+type Document []Node
+
+// Parse parses markup. It never fails: tags that are unknown or closed
+// without being opened are kept as text, tags left open are closed at the
+// end of the document.
+func Parse(s string) Document {
+	p := &parser{}
+	p.stack = []*element{{}}
+
+	for len(s) > 0 {
+		start := strings.IndexByte(s, '[')
+		if start < 0 {
+			p.text(s)
+			break
+		}
+		p.text(s[:start])
+		s = s[start:]
+
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			p.text(s)
+			break
+		}
+		if !p.tag(s[1:end]) {
+			// The bracket may open a tag after some text, such as in
+			// "[[b]bold[/b]".
+			p.text("[")
+			s = s[1:]
+			continue
+		}
+		s = s[end+1:]
+	}
+
+	for len(p.stack) > 1 {
+		p.close()
+	}
+
+	return p.stack[0].children
+}
+
+// element is a tag being parsed, waiting to be closed.
+type element struct {
+	tag      string
+	url      string
+	children []Node
+}
+
+type parser struct {
+	stack []*element
+}
+
+func (p *parser) append(node Node) {
+	current := p.stack[len(p.stack)-1]
+	// Adjacent text is merged, so that brackets kept as text don't split it.
+	if text, ok := node.(Text); ok && len(current.children) > 0 {
+		if previous, ok := current.children[len(current.children)-1].(Text); ok {
+			current.children[len(current.children)-1] = previous + text
+			return
+		}
+	}
+	current.children = append(current.children, node)
+}
+
+func (p *parser) text(s string) {
+	if s != "" {
+		p.append(Text(s))
+	}
+}
+
+// tag handles the content of a tag, between brackets, and returns false if
+// it's not a known tag.
+func (p *parser) tag(tag string) bool {
+	if name, found := strings.CutPrefix(tag, "/"); found {
+		return p.closeTag(strings.ToLower(name))
+	}
+
+	lower := strings.ToLower(tag)
+	for _, style := range styleTags {
+		if lower == style {
+			p.stack = append(p.stack, &element{tag: style})
+			return true
+		}
+	}
+	if lower == "url" {
+		p.stack = append(p.stack, &element{tag: "url"})
+		return true
+	}
+	if url, found := strings.CutPrefix(lower, "url="); found && url != "" {
+		p.stack = append(p.stack, &element{tag: "url", url: strings.TrimSpace(tag[len("url="):])})
+		return true
+	}
+
+	if reference, ok := parseReference(tag); ok {
+		p.append(reference)
+		return true
+	}
+
+	return false
+}
+
+// closeTag closes the innermost open tag named name, and the tags opened
+// within it, and returns false if no such tag is open.
+func (p *parser) closeTag(name string) bool {
+	for i := len(p.stack) - 1; i > 0; i-- {
+		if p.stack[i].tag != name {
+			continue
+		}
+		for len(p.stack) > i {
+			p.close()
+		}
+		return true
+	}
+
+	return false
+}
+
+// close closes the innermost open tag.
+func (p *parser) close() {
+	e := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+
+	if e.tag != "url" {
+		p.append(&Style{Tag: e.tag, Children: e.children})
+		return
+	}
+
+	link := &Link{URL: e.url, Children: e.children}
+	if link.URL == "" {
+		// The URL of [url]https://example.com[/url] is its text.
+		link.URL = strings.TrimSpace(plainText(e.children))
+	}
+	if link.URL == "" {
+		for _, child := range e.children {
+			p.append(child)
+		}
+		return
+	}
+	p.append(link)
+}
+
+// parseReference parses references such as "a12345" or "a=Artist Name".
+func parseReference(tag string) (Reference, bool) {
+	if len(tag) < 2 {
+		return Reference{}, false
+	}
+	kind, exists := referenceKinds[tag[0]]
+	if !exists {
+		return Reference{}, false
+	}
+
+	value := tag[1:]
+	byName := value[0] == '='
+	if byName {
+		value = strings.TrimSpace(value[1:])
+	}
+	if id, err := strconv.ParseInt(value, 10, 64); err == nil && id > 0 {
+		return Reference{Kind: kind, ID: id}, true
+	}
+	if byName && value != "" {
+		return Reference{Kind: kind, Name: value}, true
+	}
+
+	return Reference{}, false
+}
+
+// References returns the entities referenced by ID in the document, once
+// each, in order of appearance.
+func (d Document) References() []Reference {
+	references := make([]Reference, 0)
+	seen := make(map[Reference]bool)
+
+	var walk func(nodes []Node)
+	walk = func(nodes []Node) {
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case Reference:
+				if n.ID != 0 && !seen[n] {
+					seen[n] = true
+					references = append(references, n)
+				}
+			case *Style:
+				walk(n.Children)
+			case *Link:
+				walk(n.Children)
+			}
+		}
+	}
+	walk(d)
+
+	return references
+}
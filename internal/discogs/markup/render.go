@@ -0,0 +1,199 @@
+package markup
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// Formats a Document can be rendered to.
+const (
+	FormatText     = "text"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+var Formats = []string{FormatText, FormatMarkdown, FormatHTML}
+
+// Render renders the document in one of Formats.
+func (d Document) Render(format string) (string, error) {
+	switch format {
+	case FormatText:
+		return d.Text(), nil
+	case FormatMarkdown:
+		return d.Markdown(), nil
+	case FormatHTML:
+		return d.HTML(), nil
+	default:
+		return "", fmt.Errorf("supported markup formats are: %s", strings.Join(Formats, ", "))
+	}
+}
+
+// Text renders the document as plain text. Links are followed by their URL
+// in parentheses, unless it's their text.
+func (d Document) Text() string {
+	var b strings.Builder
+	for _, node := range d {
+		switch n := node.(type) {
+		case Text:
+			b.WriteString(string(n))
+		case *Style:
+			b.WriteString(Document(n.Children).Text())
+		case *Link:
+			text := Document(n.Children).Text()
+			b.WriteString(text)
+			if text != n.URL {
+				fmt.Fprintf(&b, " (%s)", n.URL)
+			}
+		case Reference:
+			b.WriteString(n.Label())
+		}
+	}
+
+	return b.String()
+}
+
+// plainText returns the text of nodes, without the URLs of their links.
+func plainText(nodes []Node) string {
+	var b strings.Builder
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case Text:
+			b.WriteString(string(n))
+		case *Style:
+			b.WriteString(plainText(n.Children))
+		case *Link:
+			b.WriteString(plainText(n.Children))
+		case Reference:
+			b.WriteString(n.Label())
+		}
+	}
+
+	return b.String()
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`<`, `\<`,
+	`~`, `\~`,
+)
+
+var markdownStyles = map[string]string{
+	"b": "**",
+	"i": "*",
+	"s": "~~",
+}
+
+// Markdown renders the document as CommonMark, with the strikethrough
+// extension of GitHub Flavored Markdown. Underlined text is left as is.
+func (d Document) Markdown() string {
+	var b strings.Builder
+	for _, node := range d {
+		switch n := node.(type) {
+		case Text:
+			b.WriteString(markdownEscaper.Replace(string(n)))
+		case *Style:
+			delimiter := markdownStyles[n.Tag]
+			b.WriteString(delimiter)
+			b.WriteString(Document(n.Children).Markdown())
+			b.WriteString(delimiter)
+		case *Link:
+			href, ok := linkURL(n.URL)
+			if !ok {
+				b.WriteString(Document(n.Children).Markdown())
+				continue
+			}
+			// Destinations with spaces or parentheses are enclosed in
+			// angle brackets.
+			if strings.ContainsAny(href, " ()<>") {
+				href = "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(href) + ">"
+			}
+			fmt.Fprintf(&b, "[%s](%s)", unlinked(n.Children).Markdown(), href)
+		case Reference:
+			if n.ID == 0 {
+				b.WriteString(markdownEscaper.Replace(n.Label()))
+				continue
+			}
+			fmt.Fprintf(&b, "[%s](%s)", markdownEscaper.Replace(n.Label()), n.URL())
+		}
+	}
+
+	return b.String()
+}
+
+// HTML renders the document as an HTML fragment. Line breaks are kept with
+// <br> elements.
+func (d Document) HTML() string {
+	var b strings.Builder
+	for _, node := range d {
+		switch n := node.(type) {
+		case Text:
+			b.WriteString(strings.ReplaceAll(html.EscapeString(string(n)), "\n", "<br>\n"))
+		case *Style:
+			fmt.Fprintf(&b, "<%s>%s</%s>", n.Tag, Document(n.Children).HTML(), n.Tag)
+		case *Link:
+			href, ok := linkURL(n.URL)
+			if !ok {
+				b.WriteString(Document(n.Children).HTML())
+				continue
+			}
+			fmt.Fprintf(&b, `<a href="%s">%s</a>`, html.EscapeString(href), unlinked(n.Children).HTML())
+		case Reference:
+			if n.ID == 0 {
+				b.WriteString(html.EscapeString(n.Label()))
+				continue
+			}
+			fmt.Fprintf(&b, `<a href="%s">%s</a>`, n.URL(), html.EscapeString(n.Label()))
+		}
+	}
+
+	return b.String()
+}
+
+// unlinked returns nodes with the references and links they contain
+// replaced by their text, as links can't be nested.
+func unlinked(nodes []Node) Document {
+	d := make(Document, 0, len(nodes))
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *Style:
+			d = append(d, &Style{Tag: n.Tag, Children: unlinked(n.Children)})
+		case *Link:
+			d = append(d, unlinked(n.Children)...)
+		case Reference:
+			d = append(d, Text(n.Label()))
+		default:
+			d = append(d, node)
+		}
+	}
+
+	return d
+}
+
+// linkURL returns the URL links point to in Markdown and HTML, and false if
+// it isn't safe to follow. URLs without a scheme, such as www.example.com,
+// are assumed to be web pages.
+func linkURL(s string) (string, bool) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return s, true
+	case "":
+		if strings.HasPrefix(s, "//") {
+			return "https:" + s, true
+		}
+		return "https://" + s, true
+	default:
+		return "", false
+	}
+}
@@ -2,29 +2,42 @@ package discogs
 
 import (
 	"encoding/xml"
+	"time"
 )
 
 type artist struct {
-	ID          int64   `xml:"id" json:"id" parquet:"id,zstd"`
-	Name        string  `xml:"name" json:"name" parquet:"name,zstd"`
-	RealName    *string `xml:"realname" json:"real_name" parquet:"real_name,zstd"`
-	Profile     *string `xml:"profile" json:"profile" parquet:"profile,zstd"`
+	ID          int64   `xml:"id" json:"id" parquet:"id"`
+	Name        string  `xml:"name" json:"name" parquet:"name"`
+	RealName    *string `xml:"realname" json:"real_name" parquet:"real_name"`
+	Profile     *string `xml:"profile" json:"profile" parquet:"profile"`
 	DataQuality string  `xml:"data_quality" json:"data_quality" parquet:"data_quality,dict"`
 
-	URLs           []string `xml:"urls>url" json:"urls" parquet:"urls,zstd"`
+	URLs           []string `xml:"urls>url" json:"urls" parquet:"urls"`
 	Aliases        []*Name  `xml:"aliases>name" json:"aliases" parquet:"aliases"`
-	NameVariations []string `xml:"namevariations>name" json:"name_variations" parquet:"name_variations,zstd"`
+	NameVariations []string `xml:"namevariations>name" json:"name_variations" parquet:"name_variations"`
 	Members        []*Name  `xml:"members>name" json:"members" parquet:"members"`
 	Groups         []*Name  `xml:"groups>name" json:"groups" parquet:"groups"`
 }
 
 type Artist struct {
 	artist
+
+	// Derived from Name by a Normalizer, left out of JSON documents
+	// otherwise.
+	DisplayName    *string `json:"display_name,omitempty" parquet:"display_name"`
+	Disambiguation *int32  `json:"disambiguation,omitempty" parquet:"disambiguation"`
+	SortName       *string `json:"sort_name,omitempty" parquet:"sort_name"`
 }
 
 type Name struct {
-	ID   int64  `xml:"id,attr" json:"id" parquet:"id,zstd"`
-	Name string `xml:",chardata" json:"name" parquet:"name,zstd"`
+	ID   int64  `xml:"id,attr" json:"id" parquet:"id"`
+	Name string `xml:",chardata" json:"name" parquet:"name"`
+
+	// Derived from Name by a Normalizer, left out of JSON documents
+	// otherwise.
+	DisplayName    *string `xml:"-" json:"display_name,omitempty" parquet:"display_name"`
+	Disambiguation *int32  `xml:"-" json:"disambiguation,omitempty" parquet:"disambiguation"`
+	SortName       *string `xml:"-" json:"sort_name,omitempty" parquet:"sort_name"`
 }
 
 func (a *Artist) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
@@ -50,6 +63,9 @@ func (a Artist) Columns() []string {
 		"data_quality",
 		"name_variations",
 		"urls",
+		"display_name",
+		"disambiguation",
+		"sort_name",
 	}
 }
 
@@ -79,6 +95,9 @@ func (a *Artist) ToRecord() []any {
 		a.DataQuality,
 		a.NameVariations,
 		a.URLs,
+		a.DisplayName,
+		a.Disambiguation,
+		a.SortName,
 	}
 }
 
@@ -99,18 +118,18 @@ func (a *Artist) ToMembershipsRecords() [][]any {
 }
 
 type label struct {
-	ID          int64       `xml:"id" json:"id" parquet:"id,zstd"`
-	Name        string      `xml:"name" json:"name" parquet:"name,zstd"`
-	ContactInfo *string     `xml:"contactinfo" json:"contact_info" parquet:"contact_info,zstd"`
-	Profile     *string     `xml:"profile" json:"profile" parquet:"profile,zstd"`
+	ID          int64       `xml:"id" json:"id" parquet:"id"`
+	Name        string      `xml:"name" json:"name" parquet:"name"`
+	ContactInfo *string     `xml:"contactinfo" json:"contact_info" parquet:"contact_info"`
+	Profile     *string     `xml:"profile" json:"profile" parquet:"profile"`
 	DataQuality string      `xml:"data_quality" json:"data_quality" parquet:"data_quality,dict"`
-	URLs        []string    `xml:"urls>url" json:"urls" parquet:"urls,zstd"`
+	URLs        []string    `xml:"urls>url" json:"urls" parquet:"urls"`
 	SubLabels   []*SubLabel `xml:"sublabels>label" json:"sub_labels" parquet:"sub_labels"`
 }
 
 type Label struct {
 	label
-	ParentLabelID *int64 `json:"parent_label_id" parquet:"parent_label_id,zstd"`
+	ParentLabelID *int64 `json:"parent_label_id" parquet:"parent_label_id"`
 }
 
 func (l *Label) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
@@ -153,8 +172,8 @@ func (l Label) Columns() []string {
 }
 
 type SubLabel struct {
-	ID   int64  `xml:"id,attr" json:"id" parquet:"id,zstd"`
-	Name string `xml:",chardata" json:"name" parquet:"name,zstd"`
+	ID   int64  `xml:"id,attr" json:"id" parquet:"id"`
+	Name string `xml:",chardata" json:"name" parquet:"name"`
 }
 
 func (l *Label) ToRecord() []any {
@@ -170,12 +189,12 @@ func (l *Label) ToRecord() []any {
 }
 
 type master struct {
-	ID            int64           `xml:"id,attr" json:"id" parquet:"id,zstd"`
-	Title         string          `xml:"title" json:"title" parquet:"title,zstd"`
+	ID            int64           `xml:"id,attr" json:"id" parquet:"id"`
+	Title         string          `xml:"title" json:"title" parquet:"title"`
 	Year          *int32          `xml:"year" json:"year" parquet:"year,dict"`
-	MainReleaseID *int64          `xml:"main_release" json:"main_release_id" parquet:"main_release_id,zstd"`
+	MainReleaseID *int64          `xml:"main_release" json:"main_release_id" parquet:"main_release_id"`
 	DataQuality   string          `xml:"data_quality" json:"data_quality" parquet:"data_quality,dict"`
-	Notes         *string         `xml:"notes" json:"notes" parquet:"notes,zstd"`
+	Notes         *string         `xml:"notes" json:"notes" parquet:"notes"`
 	Artists       []*MasterArtist `xml:"artists>artist" json:"artists" parquet:"artists"`
 	Videos        []Video         `xml:"videos>video" json:"videos" parquet:"videos"`
 	Genres        []string        `xml:"genres>genre" json:"genres" parquet:"genres,dict"`
@@ -232,7 +251,7 @@ func (m Master) Columns() []string {
 }
 
 func (m Master) ArtistsColumns() []string {
-	return []string{"master_id", "artist_id", "name", "name_variation", "join"}
+	return []string{"master_id", "artist_id", "name", "name_variation", "join", "display_name", "disambiguation", "sort_name"}
 }
 
 func (m *Master) ToRecord() []any {
@@ -257,6 +276,9 @@ func (m *Master) ToArtistsRecords() [][]any {
 			m.Artists[i].Name,
 			m.Artists[i].Anv,
 			m.Artists[i].Join,
+			m.Artists[i].DisplayName,
+			m.Artists[i].Disambiguation,
+			m.Artists[i].SortName,
 		}
 	}
 
@@ -264,10 +286,16 @@ func (m *Master) ToArtistsRecords() [][]any {
 }
 
 type MasterArtist struct {
-	ID   int64   `xml:"id" json:"id" parquet:"id,zstd"`
-	Name string  `xml:"name" json:"name" parquet:"name,zstd"`
-	Anv  *string `xml:"anv" json:"name_variation" parquet:"name_variation,zstd"`
+	ID   int64   `xml:"id" json:"id" parquet:"id"`
+	Name string  `xml:"name" json:"name" parquet:"name"`
+	Anv  *string `xml:"anv" json:"name_variation" parquet:"name_variation"`
 	Join *string `xml:"join" json:"join" parquet:"join,dict"`
+
+	// Derived from Name by a Normalizer, left out of JSON documents
+	// otherwise.
+	DisplayName    *string `xml:"-" json:"display_name,omitempty" parquet:"display_name"`
+	Disambiguation *int32  `xml:"-" json:"disambiguation,omitempty" parquet:"disambiguation"`
+	SortName       *string `xml:"-" json:"sort_name,omitempty" parquet:"sort_name"`
 }
 
 func (m *MasterArtist) clean() {
@@ -280,11 +308,11 @@ func (m *MasterArtist) clean() {
 }
 
 type Video struct {
-	Src         string `xml:"src,attr" json:"src" parquet:"src,zstd"`
-	Duration    int32  `xml:"duration,attr" json:"duration" parquet:"duration,zstd"`
+	Src         string `xml:"src,attr" json:"src" parquet:"src"`
+	Duration    int32  `xml:"duration,attr" json:"duration" parquet:"duration"`
 	Embed       string `xml:"embed,attr" json:"embed" parquet:"embed,dict"`
-	Title       string `xml:"title" json:"title" parquet:"title,zstd"`
-	Description string `xml:"description" json:"description" parquet:"description,zstd"`
+	Title       string `xml:"title" json:"title" parquet:"title"`
+	Description string `xml:"description" json:"description" parquet:"description"`
 }
 
 type release struct {
@@ -292,9 +320,9 @@ type release struct {
 	Status      string  `xml:"status,attr" json:"status" parquet:"status,dict"`
 	Country     *string `xml:"country" json:"country" parquet:"country,dict"`
 	Released    *string `xml:"released" json:"released" parquet:"released,dict"`
-	Notes       *string `xml:"notes" json:"notes" parquet:"notes,zstd"`
+	Notes       *string `xml:"notes" json:"notes" parquet:"notes"`
 	DataQuality string  `xml:"data_quality" json:"data_quality" parquet:"data_quality,dict"`
-	Title       string  `xml:"title" json:"title" parquet:"title,zstd"`
+	Title       string  `xml:"title" json:"title" parquet:"title"`
 
 	Artists      []*MasterArtist  `xml:"artists>artist" json:"artists" parquet:"artists"`
 	Companies    []*Company       `xml:"companies>company" json:"companies" parquet:"companies"`
@@ -311,8 +339,13 @@ type release struct {
 
 type Release struct {
 	release
-	MasterID      *int64 `parquet:"master_id"`
-	IsMainRelease bool   `parquet:"is_main_release,zstd"`
+	MasterID      *int64 `json:"master_id" parquet:"master_id"`
+	IsMainRelease bool   `json:"is_main_release" parquet:"is_main_release"`
+
+	// Parsed from Released by a Normalizer, left out of JSON documents
+	// otherwise.
+	ReleasedDate      *string `json:"released_date,omitempty" parquet:"released_date"`
+	ReleasedPrecision *string `json:"released_precision,omitempty" parquet:"released_precision,dict"`
 }
 
 func (r *Release) clean() {
@@ -390,15 +423,17 @@ func (r Release) Columns() []string {
 		"companies",
 		"identifiers",
 		"series",
+		"released_date",
+		"released_precision",
 	}
 }
 
 func (r Release) ArtistsColumns() []string {
-	return []string{"release_id", "artist_id", "name", "name_variation", "join"}
+	return []string{"release_id", "artist_id", "name", "name_variation", "join", "display_name", "disambiguation", "sort_name"}
 }
 
 func (r Release) ExtraArtistsColumns() []string {
-	return []string{"release_id", "artist_id", "name", "name_variation", "role"}
+	return []string{"release_id", "artist_id", "name", "name_variation", "role", "display_name", "disambiguation", "sort_name"}
 }
 
 func (r Release) LabelsColumns() []string {
@@ -414,6 +449,9 @@ func (r *Release) ToArtistsRecords() [][]any {
 			r.Artists[i].Name,
 			r.Artists[i].Anv,
 			r.Artists[i].Join,
+			r.Artists[i].DisplayName,
+			r.Artists[i].Disambiguation,
+			r.Artists[i].SortName,
 		}
 	}
 
@@ -439,7 +477,23 @@ func (r *Release) ToRecord() []any {
 		r.Companies,
 		r.Identifiers,
 		r.Series,
+		r.releasedTime(),
+		r.ReleasedPrecision,
+	}
+}
+
+// releasedTime returns the parsed release date as a time, for the date
+// column of the database.
+func (r *Release) releasedTime() *time.Time {
+	if r.ReleasedDate == nil {
+		return nil
+	}
+	t, err := time.Parse(time.DateOnly, *r.ReleasedDate)
+	if err != nil {
+		return nil
 	}
+
+	return &t
 }
 
 func (r *Release) ToExtraArtistsRecords() [][]any {
@@ -451,6 +505,9 @@ func (r *Release) ToExtraArtistsRecords() [][]any {
 			r.ExtraArtists[i].Name,
 			r.ExtraArtists[i].Anv,
 			r.ExtraArtists[i].Role,
+			r.ExtraArtists[i].DisplayName,
+			r.ExtraArtists[i].Disambiguation,
+			r.ExtraArtists[i].SortName,
 		}
 	}
 
@@ -478,10 +535,17 @@ type masterID struct {
 
 type SubTrack struct {
 	Position     *string         `xml:"position" json:"position" parquet:"position,dict"`
-	Title        string          `xml:"title" json:"title" parquet:"title,zstd"`
-	Duration     *string         `xml:"duration" json:"duration" parquet:"duration,zstd"`
+	Title        string          `xml:"title" json:"title" parquet:"title"`
+	Duration     *string         `xml:"duration" json:"duration" parquet:"duration"`
 	Artists      []*MasterArtist `xml:"artists>artist" json:"artists" parquet:"artists"`
 	ExtraArtists []*ExtraArtist  `xml:"extraartists>artist" json:"extra_artists" parquet:"extra_artists"`
+
+	// Parsed from Duration and Position by a Normalizer, left out of JSON
+	// documents otherwise.
+	DurationSeconds *int32  `xml:"-" json:"duration_seconds,omitempty" parquet:"duration_seconds"`
+	Side            *string `xml:"-" json:"side,omitempty" parquet:"side,dict"`
+	Disc            *int32  `xml:"-" json:"disc,omitempty" parquet:"disc"`
+	Index           *int32  `xml:"-" json:"index,omitempty" parquet:"index"`
 }
 
 func (t *SubTrack) clean() {
@@ -501,11 +565,18 @@ func (t *SubTrack) clean() {
 
 type Track struct {
 	Position     *string         `xml:"position" json:"position" parquet:"position,dict"`
-	Title        string          `xml:"title" json:"title" parquet:"title,zstd"`
-	Duration     *string         `xml:"duration" json:"duration" parquet:"duration,zstd"`
+	Title        string          `xml:"title" json:"title" parquet:"title"`
+	Duration     *string         `xml:"duration" json:"duration" parquet:"duration"`
 	Artists      []*MasterArtist `xml:"artists>artist" json:"artists" parquet:"artists"`
 	ExtraArtists []*ExtraArtist  `xml:"extraartists>artist" json:"extra_artists" parquet:"extra_artists"`
 	SubTracks    []*SubTrack     `xml:"sub_tracks>track" json:"sub_tracks" parquet:"sub_tracks"`
+
+	// Parsed from Duration and Position by a Normalizer, left out of JSON
+	// documents otherwise.
+	DurationSeconds *int32  `xml:"-" json:"duration_seconds,omitempty" parquet:"duration_seconds"`
+	Side            *string `xml:"-" json:"side,omitempty" parquet:"side,dict"`
+	Disc            *int32  `xml:"-" json:"disc,omitempty" parquet:"disc"`
+	Index           *int32  `xml:"-" json:"index,omitempty" parquet:"index"`
 }
 
 func (t *Track) clean() {
@@ -529,8 +600,8 @@ func (t *Track) clean() {
 
 type Identifier struct {
 	Type        string  `xml:"type,attr" json:"type" parquet:"type,dict"`
-	Description *string `xml:"description,attr" json:"description" parquet:"description,zstd"`
-	Value       string  `xml:"value,attr" json:"value" parquet:"value,zstd"`
+	Description *string `xml:"description,attr" json:"description" parquet:"description"`
+	Value       string  `xml:"value,attr" json:"value" parquet:"value"`
 }
 
 func (i *Identifier) clean() {
@@ -540,10 +611,16 @@ func (i *Identifier) clean() {
 }
 
 type ExtraArtist struct {
-	ID   int64   `xml:"id" json:"id" parquet:"id,zstd"`
-	Name string  `xml:"name" json:"name" parquet:"name,zstd"`
-	Anv  *string `xml:"anv" json:"name_variation" parquet:"name_variation,zstd"`
+	ID   int64   `xml:"id" json:"id" parquet:"id"`
+	Name string  `xml:"name" json:"name" parquet:"name"`
+	Anv  *string `xml:"anv" json:"name_variation" parquet:"name_variation"`
 	Role *string `xml:"role" json:"role" parquet:"role,dict"`
+
+	// Derived from Name by a Normalizer, left out of JSON documents
+	// otherwise.
+	DisplayName    *string `xml:"-" json:"display_name,omitempty" parquet:"display_name"`
+	Disambiguation *int32  `xml:"-" json:"disambiguation,omitempty" parquet:"disambiguation"`
+	SortName       *string `xml:"-" json:"sort_name,omitempty" parquet:"sort_name"`
 }
 
 func (e *ExtraArtist) clean() {
@@ -557,9 +634,9 @@ func (e *ExtraArtist) clean() {
 }
 
 type ReleaseLabel struct {
-	ID    int64   `xml:"id,attr" json:"id" parquet:"id,zstd"`
-	Name  string  `xml:"name,attr" json:"name" parquet:"name,zstd"`
-	Catno *string `xml:"catno,attr" json:"catno" parquet:"catno,zstd"`
+	ID    int64   `xml:"id,attr" json:"id" parquet:"id"`
+	Name  string  `xml:"name,attr" json:"name" parquet:"name"`
+	Catno *string `xml:"catno,attr" json:"catno" parquet:"catno"`
 }
 
 func (r *ReleaseLabel) clean() {
@@ -569,12 +646,12 @@ func (r *ReleaseLabel) clean() {
 }
 
 type Company struct {
-	ID             int64   `xml:"id" json:"id" parquet:"id,zstd"`
-	Name           string  `xml:"name" json:"name" parquet:"name,zstd"`
-	EntityType     int64   `xml:"entity_type" json:"entity_type" parquet:"entity_type,zstd"`
-	EntityTypeName string  `xml:"entity_type_name" json:"entity_type_name" parquet:"entity_type_name,zstd"`
-	ResourceURL    string  `xml:"resource_url" json:"resource_url" parquet:"resource_url,zstd"`
-	Catno          *string `xml:"catno" json:"catno" parquet:"catno,zstd"`
+	ID             int64   `xml:"id" json:"id" parquet:"id"`
+	Name           string  `xml:"name" json:"name" parquet:"name"`
+	EntityType     int64   `xml:"entity_type" json:"entity_type" parquet:"entity_type"`
+	EntityTypeName string  `xml:"entity_type_name" json:"entity_type_name" parquet:"entity_type_name"`
+	ResourceURL    string  `xml:"resource_url" json:"resource_url" parquet:"resource_url"`
+	Catno          *string `xml:"catno" json:"catno" parquet:"catno"`
 }
 
 func (c *Company) clean() {
@@ -584,16 +661,16 @@ func (c *Company) clean() {
 }
 
 type ReleaseFormat struct {
-	Name         string   `xml:"name,attr" json:"name" parquet:"name,zstd"`
-	Qty          string   `xml:"qty,attr" json:"qty" parquet:"qty,zstd"`
-	Text         string   `xml:"text,attr" json:"text" parquet:"text,zstd"`
-	Descriptions []string `xml:"descriptions>description" json:"descriptions" parquet:"descriptions,zstd"`
+	Name         string   `xml:"name,attr" json:"name" parquet:"name"`
+	Qty          string   `xml:"qty,attr" json:"qty" parquet:"qty"`
+	Text         string   `xml:"text,attr" json:"text" parquet:"text"`
+	Descriptions []string `xml:"descriptions>description" json:"descriptions" parquet:"descriptions"`
 }
 
 type Serie struct {
-	ID    int64   `xml:"id,attr" json:"id" parquet:"id,zstd"`
-	Name  string  `xml:"name,attr" json:"name" parquet:"name,zstd"`
-	Catno *string `xml:"catno,attr" json:"catno" parquet:"catno,zstd"`
+	ID    int64   `xml:"id,attr" json:"id" parquet:"id"`
+	Name  string  `xml:"name,attr" json:"name" parquet:"name"`
+	Catno *string `xml:"catno,attr" json:"catno" parquet:"catno"`
 }
 
 func (s *Serie) clean() {
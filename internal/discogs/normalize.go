@@ -0,0 +1,408 @@
+package discogs
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Precisions of a parsed release date. Dumps use 00 for the unknown month
+// and day of a date.
+const (
+	PrecisionYear  = "year"
+	PrecisionMonth = "month"
+	PrecisionDay   = "day"
+)
+
+// maxUnparsedExamples is the number of distinct unparsed values kept as
+// examples for each field.
+const maxUnparsedExamples = 5
+
+// Normalizer fills the fields parsed from the free-text values of the dumps:
+// the release date of releases, with its precision, the duration in seconds,
+// side, disc and index of tracks, and the display name, disambiguation
+// number and sort name of artists and credits.
+//
+// Values that can't be parsed are kept verbatim, their parsed fields left
+// empty, and are counted to be reported. A Normalizer may be used by several
+// goroutines at once.
+type Normalizer struct {
+	mu       sync.Mutex
+	unparsed map[string]*UnparsedValues
+}
+
+// UnparsedValues reports the values of a field that couldn't be parsed.
+type UnparsedValues struct {
+	Field    string   `json:"field"`
+	Count    int64    `json:"count"`
+	Examples []string `json:"examples"`
+}
+
+// normalizedSources maps the top-level fields filled by a Normalizer to the
+// XML element they are parsed from.
+var normalizedSources = map[string]string{
+	"ReleasedDate":      "released",
+	"ReleasedPrecision": "released",
+	"DisplayName":       "name",
+	"Disambiguation":    "name",
+	"SortName":          "name",
+}
+
+// NormalizedFrom returns true if a field of the struct type t is filled by a
+// Normalizer from the given XML element of an entity.
+func NormalizedFrom(t reflect.Type, element string) bool {
+	for field, source := range normalizedSources {
+		if _, exists := t.FieldByName(field); exists && source == element {
+			return true
+		}
+	}
+
+	return false
+}
+
+func NewNormalizer() *Normalizer {
+	return &Normalizer{unparsed: make(map[string]*UnparsedValues)}
+}
+
+// Normalize fills the parsed fields of a decoded element.
+func (n *Normalizer) Normalize(element any) {
+	switch e := element.(type) {
+	case *Artist:
+		e.DisplayName, e.Disambiguation, e.SortName = normalizeName(e.Name)
+		for _, names := range [][]*Name{e.Aliases, e.Members, e.Groups} {
+			for _, name := range names {
+				name.DisplayName, name.Disambiguation, name.SortName = normalizeName(name.Name)
+			}
+		}
+	case *Master:
+		normalizeArtists(e.Artists, nil)
+	case *Release:
+		n.normalizeRelease(e)
+	}
+}
+
+func (n *Normalizer) normalizeRelease(release *Release) {
+	normalizeArtists(release.Artists, release.ExtraArtists)
+	if release.Released != nil {
+		if date, precision, ok := ParseReleased(*release.Released); ok {
+			release.ReleasedDate = &date
+			release.ReleasedPrecision = &precision
+		} else {
+			n.report("released", *release.Released)
+		}
+	}
+
+	for _, track := range release.Tracklist {
+		track.DurationSeconds, track.Side, track.Disc, track.Index = n.normalizeTrack("tracklist", track.Position, track.Duration)
+		normalizeArtists(track.Artists, track.ExtraArtists)
+		for _, subTrack := range track.SubTracks {
+			subTrack.DurationSeconds, subTrack.Side, subTrack.Disc, subTrack.Index = n.normalizeTrack("tracklist.sub_tracks", subTrack.Position, subTrack.Duration)
+			normalizeArtists(subTrack.Artists, subTrack.ExtraArtists)
+		}
+	}
+}
+
+func normalizeArtists(artists []*MasterArtist, extraArtists []*ExtraArtist) {
+	for _, artist := range artists {
+		artist.DisplayName, artist.Disambiguation, artist.SortName = normalizeName(artist.Name)
+	}
+	for _, artist := range extraArtists {
+		artist.DisplayName, artist.Disambiguation, artist.SortName = normalizeName(artist.Name)
+	}
+}
+
+func normalizeName(name string) (displayName *string, disambiguation *int32, sortName *string) {
+	if name == "" {
+		return nil, nil, nil
+	}
+
+	display, number := ParseArtistName(name)
+	// Some names are stored the way they are sorted, such as "Beatles, The".
+	for _, article := range sortArticles {
+		if rest, found := strings.CutSuffix(display, ", "+article); found && rest != "" {
+			display = article + " " + rest
+			break
+		}
+	}
+	sort := SortName(display)
+	if number != 0 {
+		disambiguation = &number
+	}
+
+	return &display, disambiguation, &sort
+}
+
+func (n *Normalizer) normalizeTrack(field string, position *string, duration *string) (seconds *int32, side *string, disc *int32, index *int32) {
+	if duration != nil {
+		if parsed, ok := ParseDuration(*duration); ok {
+			seconds = &parsed
+		} else {
+			n.report(field+".duration", *duration)
+		}
+	}
+
+	if position != nil {
+		parsed, ok := ParsePosition(*position)
+		if !ok {
+			n.report(field+".position", *position)
+			return
+		}
+		if parsed.Side != "" {
+			side = &parsed.Side
+		}
+		if parsed.Disc != 0 {
+			disc = &parsed.Disc
+		}
+		if parsed.Index != 0 {
+			index = &parsed.Index
+		}
+	}
+
+	return
+}
+
+func (n *Normalizer) report(field string, value string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	unparsed, exists := n.unparsed[field]
+	if !exists {
+		unparsed = &UnparsedValues{Field: field, Examples: make([]string, 0, maxUnparsedExamples)}
+		n.unparsed[field] = unparsed
+	}
+	unparsed.Count++
+	if len(unparsed.Examples) < maxUnparsedExamples && !slices.Contains(unparsed.Examples, value) {
+		unparsed.Examples = append(unparsed.Examples, value)
+	}
+}
+
+// Unparsed returns the values that couldn't be parsed so far, by field.
+func (n *Normalizer) Unparsed() []UnparsedValues {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	unparsed := make([]UnparsedValues, 0, len(n.unparsed))
+	for _, values := range n.unparsed {
+		unparsed = append(unparsed, UnparsedValues{
+			Field:    values.Field,
+			Count:    values.Count,
+			Examples: slices.Clone(values.Examples),
+		})
+	}
+	slices.SortFunc(unparsed, func(a, b UnparsedValues) int {
+		return strings.Compare(a.Field, b.Field)
+	})
+
+	return unparsed
+}
+
+func (u UnparsedValues) String() string {
+	examples := make([]string, len(u.Examples))
+	for i, example := range u.Examples {
+		examples[i] = strconv.Quote(example)
+	}
+
+	return fmt.Sprintf("%d unparseable %s values kept verbatim, such as %s", u.Count, u.Field, strings.Join(examples, ", "))
+}
+
+// ParseArtistName splits the disambiguation number Discogs appends to the
+// names shared by several artists, such as "John Smith (12)", from the name
+// they are displayed with. The number is 0 when there is none.
+func ParseArtistName(name string) (string, int32) {
+	i := strings.LastIndex(name, " (")
+	if i < 0 || !strings.HasSuffix(name, ")") {
+		return name, 0
+	}
+
+	suffix := name[i+len(" (") : len(name)-len(")")]
+	if strings.Trim(suffix, "0123456789") != "" {
+		return name, 0
+	}
+	number, err := strconv.ParseInt(suffix, 10, 32)
+	if err != nil || number == 0 {
+		return name, 0
+	}
+
+	return name[:i], int32(number)
+}
+
+// sortArticles are the leading articles moved to the end of sort names.
+var sortArticles = []string{"The", "A", "An"}
+
+// SortName returns the name an artist is sorted by, with its leading article
+// moved to the end, such as "Beatles, The" for "The Beatles".
+func SortName(name string) string {
+	for _, article := range sortArticles {
+		rest, found := strings.CutPrefix(name, article+" ")
+		if found && strings.TrimSpace(rest) != "" {
+			return strings.TrimSpace(rest) + ", " + article
+		}
+	}
+
+	return name
+}
+
+// ParseDuration parses a track duration such as "3:45" or "1:02:10" into a
+// number of seconds.
+func ParseDuration(s string) (int32, bool) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return 0, false
+	}
+
+	var seconds int64
+	for i, part := range parts {
+		if len(part) == 0 || len(part) > 4 || strings.Trim(part, "0123456789") != "" {
+			return 0, false
+		}
+		// Only the leading unit may be more than 59.
+		value, _ := strconv.ParseInt(part, 10, 64)
+		if i > 0 && (value > 59 || len(part) != 2) {
+			return 0, false
+		}
+		seconds = seconds*60 + value
+	}
+
+	return int32(seconds), true
+}
+
+// ParseReleased parses the release date of a release, such as "1995",
+// "1995-03-00" or "1995-03-12", into an ISO 8601 date and its precision. The
+// unknown month and day of the date are set to the first of the year or
+// month.
+func ParseReleased(s string) (date string, precision string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) > 3 {
+		return "", "", false
+	}
+	for i, part := range parts {
+		if (i == 0 && len(part) != 4) || (i > 0 && len(part) != 2) || strings.Trim(part, "0123456789") != "" {
+			return "", "", false
+		}
+	}
+
+	values := []int{1, 1, 1}
+	for i, part := range parts {
+		values[i], _ = strconv.Atoi(part)
+	}
+	year, month, day := values[0], values[1], values[2]
+	if year == 0 {
+		return "", "", false
+	}
+
+	// A day without a month isn't much of a date.
+	switch {
+	case len(parts) == 1 || month == 0:
+		return fmt.Sprintf("%04d-01-01", year), PrecisionYear, true
+	case len(parts) == 2 || day == 0:
+		if month > 12 {
+			return "", "", false
+		}
+		return fmt.Sprintf("%04d-%02d-01", year, month), PrecisionMonth, true
+	}
+
+	parsed := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if parsed.Year() != year || int(parsed.Month()) != month || parsed.Day() != day {
+		return "", "", false
+	}
+
+	return parsed.Format(time.DateOnly), PrecisionDay, true
+}
+
+const asciiLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// TrackPosition is a track position parsed with ParsePosition. Zero values
+// are absent from the position.
+type TrackPosition struct {
+	// Side is the side of a vinyl or a tape, such as A, B or AA.
+	Side string
+	// Disc is the number of the disc of a multi-disc release, starting at 1.
+	Disc int32
+	// Index is the number of the track on its side or disc.
+	Index int32
+}
+
+// ParsePosition parses a track position, such as "A1", "CD2-3" or "1.a". An
+// empty position, such as the position of headings, has no side, disc nor
+// index.
+//
+// Positions may start with a disc number followed by a dash, optionally
+// prefixed with the name of the medium, then a side and a track number,
+// optionally followed by a sub-track suffix such as "a" or ".2", which is
+// left out of the index.
+func ParsePosition(s string) (TrackPosition, bool) {
+	var position TrackPosition
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return position, true
+	}
+
+	if medium, rest, found := strings.Cut(s, "-"); found {
+		disc, ok := parsePositionNumber(strings.TrimLeft(medium, asciiLetters))
+		if !ok {
+			return position, false
+		}
+		position.Disc = disc
+		s = rest
+	}
+
+	side := s[:len(s)-len(strings.TrimLeft(s, asciiLetters))]
+	switch {
+	case len(side) == 1, len(side) == 2 && side[0] == side[1]:
+		position.Side = strings.ToUpper(side)
+	case len(side) > 0:
+		return position, false
+	}
+	s = s[len(side):]
+
+	digits := s[:len(s)-len(strings.TrimLeft(s, "0123456789"))]
+	if digits != "" {
+		index, ok := parsePositionNumber(digits)
+		if !ok {
+			return position, false
+		}
+		position.Index = index
+	}
+	if position.Side == "" && position.Index == 0 {
+		return position, false
+	}
+
+	if !isSubTrackSuffix(s[len(digits):]) || (digits == "" && s[len(digits):] != "") {
+		return position, false
+	}
+
+	return position, true
+}
+
+func parsePositionNumber(s string) (int32, bool) {
+	if len(s) == 0 || len(s) > 4 || strings.Trim(s, "0123456789") != "" {
+		return 0, false
+	}
+	n, _ := strconv.Atoi(s)
+	if n == 0 {
+		return 0, false
+	}
+
+	return int32(n), true
+}
+
+// isSubTrackSuffix returns true if s is empty or the suffix of a sub-track
+// position, such as "a", ".a" or ".2".
+func isSubTrackSuffix(s string) bool {
+	if s == "" {
+		return true
+	}
+	if len(s) == 1 {
+		return s[0] >= 'a' && s[0] <= 'z'
+	}
+	if s[0] != '.' || len(s) == 1 {
+		return false
+	}
+	s = s[1:]
+
+	return (len(s) == 1 && s[0] >= 'a' && s[0] <= 'z') || (len(s) <= 2 && strings.Trim(s, "0123456789") == "")
+}
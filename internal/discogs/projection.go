@@ -0,0 +1,305 @@
+package discogs
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Projection selects a subset of the fields of an entity.
+//
+// Fields are referenced by their JSON names, nested fields with dotted paths
+// such as "labels.catno". Projected entities are pointers to a struct type
+// built at runtime which keeps the json and parquet tags of the original
+// fields, so they encode like the original entities, minus the fields that
+// were not selected.
+type Projection struct {
+	source reflect.Type
+	root   *projectedStruct
+	skip   []string
+}
+
+// fieldTree is a set of dotted paths, split on dots.
+type fieldTree map[string]fieldTree
+
+func newFieldTree(paths []string) fieldTree {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	tree := fieldTree{}
+	for _, path := range paths {
+		node := tree
+		for _, segment := range strings.Split(path, ".") {
+			child, exists := node[segment]
+			if !exists {
+				child = fieldTree{}
+				node[segment] = child
+			}
+			node = child
+		}
+	}
+
+	return tree
+}
+
+type projectedStruct struct {
+	typ    reflect.Type
+	fields []projectedField
+}
+
+type projectedField struct {
+	source []int
+	target int
+	copy   func(dst, src reflect.Value)
+}
+
+// NewProjection returns the projection of entity keeping the include paths,
+// or every field if include is empty, minus the exclude paths.
+func NewProjection(entity any, include []string, exclude []string) (*Projection, error) {
+	t := reflect.TypeOf(entity)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot project values of type %T", entity)
+	}
+
+	includeTree := newFieldTree(include)
+	excludeTree := newFieldTree(exclude)
+
+	root, err := projectStruct(t, includeTree, excludeTree, "")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Projection{
+		source: t,
+		root:   root,
+	}
+
+	// Top-level XML elements that don't feed any projected field can be
+	// skipped entirely by the decoder.
+	for _, field := range reflect.VisibleFields(t) {
+		if field.Anonymous || !field.IsExported() {
+			continue
+		}
+		element := xmlElementName(field)
+		if element == "" {
+			continue
+		}
+		name := jsonName(field)
+		if includeTree != nil {
+			if _, selected := includeTree[name]; !selected {
+				p.skip = append(p.skip, element)
+				continue
+			}
+		}
+		if excluded, exists := excludeTree[name]; exists && len(excluded) == 0 {
+			p.skip = append(p.skip, element)
+		}
+	}
+
+	return p, nil
+}
+
+// Type returns the struct type of projected entities.
+func (p *Projection) Type() reflect.Type {
+	return p.root.typ
+}
+
+// SkippedElements returns the names of the top-level XML elements of the
+// entity that are not needed to build the projection.
+func (p *Projection) SkippedElements() []string {
+	return p.skip
+}
+
+// Project returns a pointer to the projection of entity.
+func (p *Projection) Project(entity any) (any, error) {
+	v := reflect.ValueOf(entity)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, fmt.Errorf("cannot project nil %T", entity)
+		}
+		v = v.Elem()
+	}
+	if v.Type() != p.source {
+		return nil, fmt.Errorf("cannot project %T with a projection of %s", entity, p.source)
+	}
+
+	projected := reflect.New(p.root.typ)
+	p.root.copy(projected.Elem(), v)
+
+	return projected.Interface(), nil
+}
+
+func (s *projectedStruct) copy(dst, src reflect.Value) {
+	for _, field := range s.fields {
+		field.copy(dst.Field(field.target), src.FieldByIndex(field.source))
+	}
+}
+
+func projectStruct(t reflect.Type, include, exclude fieldTree, prefix string) (*projectedStruct, error) {
+	visible := make(map[string]reflect.StructField)
+	for _, field := range reflect.VisibleFields(t) {
+		if field.Anonymous || !field.IsExported() {
+			continue
+		}
+		visible[jsonName(field)] = field
+	}
+	for name := range include {
+		if _, exists := visible[name]; !exists {
+			return nil, fmt.Errorf("unknown field %q", prefix+name)
+		}
+	}
+	for name := range exclude {
+		if _, exists := visible[name]; !exists {
+			return nil, fmt.Errorf("unknown field %q", prefix+name)
+		}
+	}
+
+	s := &projectedStruct{}
+	structFields := make([]reflect.StructField, 0)
+
+	for _, field := range reflect.VisibleFields(t) {
+		if field.Anonymous || !field.IsExported() {
+			continue
+		}
+		name := jsonName(field)
+
+		var childInclude, childExclude fieldTree
+		if include != nil {
+			selected, exists := include[name]
+			if !exists {
+				continue
+			}
+			if len(selected) > 0 {
+				childInclude = selected
+			}
+		}
+		if excluded, exists := exclude[name]; exists {
+			if len(excluded) == 0 {
+				continue
+			}
+			childExclude = excluded
+		}
+
+		typ, copyFn, err := projectType(field.Type, childInclude, childExclude, prefix+name+".")
+		if err != nil {
+			return nil, err
+		}
+
+		tag := ""
+		if value, ok := field.Tag.Lookup("json"); ok {
+			tag += fmt.Sprintf(`json:%q`, value)
+		}
+		if value, ok := field.Tag.Lookup("parquet"); ok {
+			if tag != "" {
+				tag += " "
+			}
+			tag += fmt.Sprintf(`parquet:%q`, value)
+		}
+
+		s.fields = append(s.fields, projectedField{
+			source: field.Index,
+			target: len(structFields),
+			copy:   copyFn,
+		})
+		structFields = append(structFields, reflect.StructField{
+			Name: field.Name,
+			Type: typ,
+			Tag:  reflect.StructTag(tag),
+		})
+	}
+
+	if len(structFields) == 0 {
+		return nil, fmt.Errorf("no field selected in %q", strings.TrimSuffix(prefix, "."))
+	}
+
+	s.typ = reflect.StructOf(structFields)
+	return s, nil
+}
+
+// projectType returns the projected type of t and the function copying a
+// value of type t into a value of the projected type.
+func projectType(t reflect.Type, include, exclude fieldTree, prefix string) (reflect.Type, func(dst, src reflect.Value), error) {
+	switch t.Kind() {
+	case reflect.Struct:
+		if include == nil && exclude == nil {
+			return t, assign, nil
+		}
+		s, err := projectStruct(t, include, exclude, prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s.typ, s.copy, nil
+	case reflect.Pointer:
+		elem, copyElem, err := projectType(t.Elem(), include, exclude, prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		if elem == t.Elem() {
+			return t, assign, nil
+		}
+		return reflect.PointerTo(elem), func(dst, src reflect.Value) {
+			if src.IsNil() {
+				return
+			}
+			dst.Set(reflect.New(elem))
+			copyElem(dst.Elem(), src.Elem())
+		}, nil
+	case reflect.Slice:
+		elem, copyElem, err := projectType(t.Elem(), include, exclude, prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		if elem == t.Elem() {
+			return t, assign, nil
+		}
+		return reflect.SliceOf(elem), func(dst, src reflect.Value) {
+			if src.IsNil() {
+				return
+			}
+			dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+			for i := 0; i < src.Len(); i++ {
+				copyElem(dst.Index(i), src.Index(i))
+			}
+		}, nil
+	default:
+		if include != nil || exclude != nil {
+			return nil, nil, fmt.Errorf("field %q has no nested fields", strings.TrimSuffix(prefix, "."))
+		}
+		return t, assign, nil
+	}
+}
+
+func assign(dst, src reflect.Value) {
+	dst.Set(src)
+}
+
+// jsonName returns the name of the field in JSON documents.
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// xmlElementName returns the name of the XML element a field is decoded
+// from, or an empty string if it's decoded from an attribute, character data
+// or not from XML at all.
+func xmlElementName(field reflect.StructField) string {
+	tag, ok := field.Tag.Lookup("xml")
+	if !ok {
+		return ""
+	}
+	name, flags, _ := strings.Cut(tag, ",")
+	if slices.Contains(strings.Split(flags, ","), "attr") || name == "" {
+		return ""
+	}
+	name, _, _ = strings.Cut(name, ">")
+
+	return name
+}
@@ -0,0 +1,305 @@
+package discogs
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The AppendProto methods append the Protocol Buffers wire encoding of the
+// entities, as described by proto/discogs/v1/discogs.proto, to b.
+//
+// Entities are encoded by hand rather than converted to generated messages,
+// which would mean copying every entity of a dump once more.
+
+type protoMessage interface {
+	AppendProto(b []byte) []byte
+}
+
+func (a *Artist) AppendProto(b []byte) []byte {
+	b = appendInt64(b, 1, a.ID)
+	b = appendString(b, 2, a.Name)
+	b = appendOptionalString(b, 3, a.RealName)
+	b = appendOptionalString(b, 4, a.Profile)
+	b = appendString(b, 5, a.DataQuality)
+	b = appendStrings(b, 6, a.URLs)
+	b = appendMessages(b, 7, a.Aliases)
+	b = appendStrings(b, 8, a.NameVariations)
+	b = appendMessages(b, 9, a.Members)
+	b = appendMessages(b, 10, a.Groups)
+	b = appendOptionalString(b, 11, a.DisplayName)
+	b = appendOptionalInt32(b, 12, a.Disambiguation)
+	b = appendOptionalString(b, 13, a.SortName)
+
+	return b
+}
+
+func (n *Name) AppendProto(b []byte) []byte {
+	b = appendInt64(b, 1, n.ID)
+	b = appendString(b, 2, n.Name)
+	b = appendOptionalString(b, 3, n.DisplayName)
+	b = appendOptionalInt32(b, 4, n.Disambiguation)
+	b = appendOptionalString(b, 5, n.SortName)
+
+	return b
+}
+
+func (l *Label) AppendProto(b []byte) []byte {
+	b = appendInt64(b, 1, l.ID)
+	b = appendString(b, 2, l.Name)
+	b = appendOptionalString(b, 3, l.ContactInfo)
+	b = appendOptionalString(b, 4, l.Profile)
+	b = appendString(b, 5, l.DataQuality)
+	b = appendStrings(b, 6, l.URLs)
+	b = appendMessages(b, 7, l.SubLabels)
+	b = appendOptionalInt64(b, 8, l.ParentLabelID)
+
+	return b
+}
+
+func (s *SubLabel) AppendProto(b []byte) []byte {
+	b = appendInt64(b, 1, s.ID)
+	b = appendString(b, 2, s.Name)
+
+	return b
+}
+
+func (m *Master) AppendProto(b []byte) []byte {
+	b = appendInt64(b, 1, m.ID)
+	b = appendString(b, 2, m.Title)
+	b = appendOptionalInt32(b, 3, m.Year)
+	b = appendOptionalInt64(b, 4, m.MainReleaseID)
+	b = appendString(b, 5, m.DataQuality)
+	b = appendOptionalString(b, 6, m.Notes)
+	b = appendMessages(b, 7, m.Artists)
+	for i := range m.Videos {
+		b = appendMessage(b, 8, &m.Videos[i])
+	}
+	b = appendStrings(b, 9, m.Genres)
+	b = appendStrings(b, 10, m.Styles)
+
+	return b
+}
+
+func (m *MasterArtist) AppendProto(b []byte) []byte {
+	b = appendInt64(b, 1, m.ID)
+	b = appendString(b, 2, m.Name)
+	b = appendOptionalString(b, 3, m.Anv)
+	b = appendOptionalString(b, 4, m.Join)
+	b = appendOptionalString(b, 5, m.DisplayName)
+	b = appendOptionalInt32(b, 6, m.Disambiguation)
+	b = appendOptionalString(b, 7, m.SortName)
+
+	return b
+}
+
+func (v *Video) AppendProto(b []byte) []byte {
+	b = appendString(b, 1, v.Src)
+	b = appendInt64(b, 2, int64(v.Duration))
+	b = appendString(b, 3, v.Embed)
+	b = appendString(b, 4, v.Title)
+	b = appendString(b, 5, v.Description)
+
+	return b
+}
+
+func (r *Release) AppendProto(b []byte) []byte {
+	b = appendInt64(b, 1, r.ID)
+	b = appendString(b, 2, r.Status)
+	b = appendOptionalString(b, 3, r.Country)
+	b = appendOptionalString(b, 4, r.Released)
+	b = appendOptionalString(b, 5, r.Notes)
+	b = appendString(b, 6, r.DataQuality)
+	b = appendString(b, 7, r.Title)
+	b = appendMessages(b, 8, r.Artists)
+	b = appendMessages(b, 9, r.Companies)
+	b = appendMessages(b, 10, r.ExtraArtists)
+	b = appendMessages(b, 11, r.Formats)
+	b = appendStrings(b, 12, r.Genres)
+	b = appendMessages(b, 13, r.Identifiers)
+	b = appendMessages(b, 14, r.Labels)
+	b = appendMessages(b, 15, r.Series)
+	b = appendStrings(b, 16, r.Styles)
+	b = appendMessages(b, 17, r.Tracklist)
+	b = appendMessages(b, 18, r.Videos)
+	b = appendOptionalInt64(b, 19, r.MasterID)
+	if r.IsMainRelease {
+		b = protowire.AppendTag(b, 20, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	b = appendOptionalString(b, 21, r.ReleasedDate)
+	b = appendOptionalString(b, 22, r.ReleasedPrecision)
+
+	return b
+}
+
+func (c *Company) AppendProto(b []byte) []byte {
+	b = appendInt64(b, 1, c.ID)
+	b = appendString(b, 2, c.Name)
+	b = appendInt64(b, 3, c.EntityType)
+	b = appendString(b, 4, c.EntityTypeName)
+	b = appendString(b, 5, c.ResourceURL)
+	b = appendOptionalString(b, 6, c.Catno)
+
+	return b
+}
+
+func (e *ExtraArtist) AppendProto(b []byte) []byte {
+	b = appendInt64(b, 1, e.ID)
+	b = appendString(b, 2, e.Name)
+	b = appendOptionalString(b, 3, e.Anv)
+	b = appendOptionalString(b, 4, e.Role)
+	b = appendOptionalString(b, 5, e.DisplayName)
+	b = appendOptionalInt32(b, 6, e.Disambiguation)
+	b = appendOptionalString(b, 7, e.SortName)
+
+	return b
+}
+
+func (f *ReleaseFormat) AppendProto(b []byte) []byte {
+	b = appendString(b, 1, f.Name)
+	b = appendString(b, 2, f.Qty)
+	b = appendString(b, 3, f.Text)
+	b = appendStrings(b, 4, f.Descriptions)
+
+	return b
+}
+
+func (i *Identifier) AppendProto(b []byte) []byte {
+	b = appendString(b, 1, i.Type)
+	b = appendOptionalString(b, 2, i.Description)
+	b = appendString(b, 3, i.Value)
+
+	return b
+}
+
+func (r *ReleaseLabel) AppendProto(b []byte) []byte {
+	b = appendInt64(b, 1, r.ID)
+	b = appendString(b, 2, r.Name)
+	b = appendOptionalString(b, 3, r.Catno)
+
+	return b
+}
+
+func (s *Serie) AppendProto(b []byte) []byte {
+	b = appendInt64(b, 1, s.ID)
+	b = appendString(b, 2, s.Name)
+	b = appendOptionalString(b, 3, s.Catno)
+
+	return b
+}
+
+func (t *Track) AppendProto(b []byte) []byte {
+	b = appendOptionalString(b, 1, t.Position)
+	b = appendString(b, 2, t.Title)
+	b = appendOptionalString(b, 3, t.Duration)
+	b = appendMessages(b, 4, t.Artists)
+	b = appendMessages(b, 5, t.ExtraArtists)
+	b = appendMessages(b, 6, t.SubTracks)
+	b = appendOptionalInt32(b, 7, t.DurationSeconds)
+	b = appendOptionalString(b, 8, t.Side)
+	b = appendOptionalInt32(b, 9, t.Disc)
+	b = appendOptionalInt32(b, 10, t.Index)
+
+	return b
+}
+
+func (t *SubTrack) AppendProto(b []byte) []byte {
+	b = appendOptionalString(b, 1, t.Position)
+	b = appendString(b, 2, t.Title)
+	b = appendOptionalString(b, 3, t.Duration)
+	b = appendMessages(b, 4, t.Artists)
+	b = appendMessages(b, 5, t.ExtraArtists)
+	b = appendOptionalInt32(b, 6, t.DurationSeconds)
+	b = appendOptionalString(b, 7, t.Side)
+	b = appendOptionalInt32(b, 8, t.Disc)
+	b = appendOptionalInt32(b, 9, t.Index)
+
+	return b
+}
+
+// Fields without presence are left out when they hold their zero value, as
+// proto3 does.
+
+func appendInt64(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendOptionalInt64(b []byte, num protowire.Number, v *int64) []byte {
+	if v == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+
+	return protowire.AppendVarint(b, uint64(*v))
+}
+
+func appendOptionalInt32(b []byte, num protowire.Number, v *int32) []byte {
+	if v == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+
+	return protowire.AppendVarint(b, uint64(int64(*v)))
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+
+	return protowire.AppendString(b, s)
+}
+
+func appendOptionalString(b []byte, num protowire.Number, s *string) []byte {
+	if s == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+
+	return protowire.AppendString(b, *s)
+}
+
+func appendStrings(b []byte, num protowire.Number, values []string) []byte {
+	for _, s := range values {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+
+	return b
+}
+
+func appendMessages[M protoMessage](b []byte, num protowire.Number, messages []M) []byte {
+	for _, m := range messages {
+		b = appendMessage(b, num, m)
+	}
+
+	return b
+}
+
+// appendMessage appends a nested message in place. Its length is only known
+// once it's encoded, so a single byte is reserved for it, which is enough for
+// most nested messages, and the message is moved when it's not.
+func appendMessage(b []byte, num protowire.Number, m protoMessage) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	start := len(b)
+	b = append(b, 0)
+	b = m.AppendProto(b)
+
+	size := len(b) - start - 1
+	if size < 0x80 {
+		b[start] = byte(size)
+		return b
+	}
+
+	length := protowire.AppendVarint(nil, uint64(size))
+	b = append(b, length[1:]...)
+	copy(b[start+len(length):], b[start+1:start+1+size])
+	copy(b[start:], length)
+
+	return b
+}
@@ -0,0 +1,238 @@
+package discogs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protoFile compiles the schema the entities are encoded after.
+func protoFile(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+
+	compiler := protocompile.Compiler{
+		Resolver: &protocompile.SourceResolver{ImportPaths: []string{"../../proto"}},
+	}
+	files, err := compiler.Compile(context.Background(), "discogs/v1/discogs.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files[0]
+}
+
+// assertProto decodes the wire encoding of an entity with the message of the
+// schema named after its type, and compares the message with the entity.
+func assertProto(t *testing.T, file protoreflect.FileDescriptor, entity protoMessage) {
+	t.Helper()
+
+	name := reflect.TypeOf(entity).Elem().Name()
+	descriptor := file.Messages().ByName(protoreflect.Name(name))
+	if descriptor == nil {
+		t.Fatalf("no %s message in %s", name, file.Path())
+	}
+
+	message := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(entity.AppendProto(nil), message); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	compareProto(t, name, message, reflect.ValueOf(entity))
+}
+
+// compareProto compares every field of a message with the field of the Go
+// value named after it in JSON documents.
+func compareProto(t *testing.T, path string, m protoreflect.Message, v reflect.Value) {
+	t.Helper()
+
+	if len(m.GetUnknown()) > 0 {
+		t.Errorf("%s: unknown fields %x", path, m.GetUnknown())
+	}
+
+	fields := make(map[string]reflect.Value)
+	jsonFields(reflect.Indirect(v), fields)
+
+	descriptors := m.Descriptor().Fields()
+	for i := range descriptors.Len() {
+		fd := descriptors.Get(i)
+		fieldPath := path + "." + string(fd.Name())
+		gv, exists := fields[string(fd.Name())]
+		if !exists {
+			t.Errorf("%s: no Go field", fieldPath)
+			continue
+		}
+		delete(fields, string(fd.Name()))
+
+		switch {
+		case fd.IsList():
+			list := m.Get(fd).List()
+			if list.Len() != gv.Len() {
+				t.Errorf("%s: got %d values, want %d", fieldPath, list.Len(), gv.Len())
+				continue
+			}
+			for j := range list.Len() {
+				elementPath := fieldPath + "[" + strconv.Itoa(j) + "]"
+				if fd.Message() != nil {
+					compareProto(t, elementPath, list.Get(j).Message(), gv.Index(j))
+				} else {
+					compareProtoScalar(t, elementPath, list.Get(j), gv.Index(j))
+				}
+			}
+		case gv.Kind() == reflect.Pointer:
+			if gv.IsNil() {
+				if m.Has(fd) {
+					t.Errorf("%s: got %v, want no value", fieldPath, m.Get(fd))
+				}
+				continue
+			}
+			if !m.Has(fd) {
+				t.Errorf("%s: got no value, want %v", fieldPath, gv.Elem())
+				continue
+			}
+			compareProtoScalar(t, fieldPath, m.Get(fd), gv.Elem())
+		default:
+			compareProtoScalar(t, fieldPath, m.Get(fd), gv)
+		}
+	}
+
+	for name := range fields {
+		t.Errorf("%s.%s: missing from the message", path, name)
+	}
+}
+
+func compareProtoScalar(t *testing.T, path string, pv protoreflect.Value, gv reflect.Value) {
+	t.Helper()
+
+	var got, want any
+	switch gv.Kind() {
+	case reflect.String:
+		got, want = pv.String(), gv.String()
+	case reflect.Int32, reflect.Int64:
+		got, want = pv.Int(), gv.Int()
+	case reflect.Bool:
+		got, want = pv.Bool(), gv.Bool()
+	default:
+		t.Fatalf("%s: unsupported kind %s", path, gv.Kind())
+	}
+	if got != want {
+		t.Errorf("%s: got %v, want %v", path, got, want)
+	}
+}
+
+// jsonFields collects the fields of a struct by their JSON name, including
+// the fields of embedded structs.
+func jsonFields(v reflect.Value, fields map[string]reflect.Value) {
+	for i := range v.NumField() {
+		f := v.Type().Field(i)
+		if f.Anonymous {
+			jsonFields(v.Field(i), fields)
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = v.Field(i)
+		}
+	}
+}
+
+func decodeProtoSample(t *testing.T, raw string) any {
+	t.Helper()
+
+	element, err := DecodeRawElement([]byte(raw), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewNormalizer().Normalize(element)
+
+	return element
+}
+
+func TestAppendProto(t *testing.T) {
+	file := protoFile(t)
+	// Nested messages over 127 bytes have a length of several bytes.
+	long := strings.Repeat("Long name ", 20)
+	longer := strings.Repeat("Very long title ", 1200)
+
+	samples := []string{
+		`<artist><id>1</id><name>The Persuader (2)</name><realname>Jesper Dahlbäck</realname>` +
+			`<profile>Swedish producer, see [a=Jesper Dahlbäck].</profile><data_quality>Needs Vote</data_quality>` +
+			`<urls><url>https://example.com</url><url>https://example.org</url></urls>` +
+			`<namevariations><name>Persuader</name><name>The Presuader</name></namevariations>` +
+			`<aliases><name id="239">Jesper Dahlbäck</name><name id="16055">Groove Machine</name></aliases>` +
+			`<members><name id="42">` + long + `</name></members><groups><name id="7">The Group, The</name></groups></artist>`,
+		`<artist><id>2</id><name>Minimal</name><data_quality>Correct</data_quality></artist>`,
+		`<label><id>1</id><name>Planet E</name><contactinfo>Detroit</contactinfo><profile>` + long + `</profile>` +
+			`<data_quality>Correct</data_quality><urls><url>https://planet-e.net</url></urls>` +
+			`<sublabels><label id="86537">Antidote (4)</label><label id="41841">` + long + `</label></sublabels>` +
+			`<parentLabel id="9">Parent</parentLabel></label>`,
+		`<label><id>2</id><name>Empty</name></label>`,
+		`<master id="18500"><main_release>155102</main_release><artists><artist><id>212070</id><name>Samuel L Session</name>` +
+			`<anv>Samuel L</anv><join>,</join></artist><artist><id>-1</id><name>` + long + `</name></artist></artists>` +
+			`<genres><genre>Electronic</genre></genres><styles><style>Techno</style></styles><year>2001</year>` +
+			`<title>New Soul</title><data_quality>Correct</data_quality><notes>` + long + `</notes>` +
+			`<videos><video src="https://www.youtube.com/watch?v=f05Ai921itM" duration="380" embed="true">` +
+			`<title>Samuel L - Velvet</title><description>` + long + `</description></video></videos></master>`,
+		`<master id="2"><title>No year</title><year>0</year></master>`,
+		`<release id="3" status="Accepted"><title>` + longer + `</title><country>US</country><released>1999-03-00</released>` +
+			`<master_id is_main_release="true">4</master_id><data_quality>Correct</data_quality>` +
+			`<companies><company><id>1</id><name>Pressing</name><entity_type>17</entity_type>` +
+			`<entity_type_name>Pressed By</entity_type_name><resource_url>https://api.discogs.com/labels/1</resource_url>` +
+			`<catno>P-1</catno></company><company><id>2</id><name>Studio</name></company></companies>` +
+			`<extraartists><artist><id>5</id><name>Producer (3)</name><anv></anv><role>Producer</role></artist></extraartists>` +
+			`<formats><format name="Vinyl" qty="2" text="Gatefold"><descriptions><description>LP</description>` +
+			`<description>Album</description></descriptions></format><format name="CD" qty="1" text=""/></formats>` +
+			`<identifiers><identifier type="Barcode" description="Text" value="0 12345 6"/><identifier type="Matrix / Runout" value="A1"/></identifiers>` +
+			`<labels><label name="Label" catno="CAT 1" id="6"/></labels><series><serie name="Series" catno="" id="8"/></series>` +
+			`<tracklist><track><position>A1</position><title>` + longer + `</title><duration>7:30</duration>` +
+			`<artists><artist><id>9</id><name>Track Artist</name></artist></artists>` +
+			`<extraartists><artist><id>10</id><name>Remixer</name><role>Remix</role></artist></extraartists>` +
+			`<sub_tracks><track><position>A1.a</position><title>` + long + `</title><duration>3:15</duration>` +
+			`<extraartists><artist><id>11</id><name>Featuring</name><role>Featuring</role></artist></extraartists></track>` +
+			`<track><title>Untimed</title></track></sub_tracks></track><track><position>B</position><title>Side B</title></track></tracklist>` +
+			`<videos><video src="https://www.youtube.com/watch?v=1" duration="200" embed="false"><title>Video</title>` +
+			`<description></description></video></videos></release>`,
+		`<release id="4" status="Draft"><title>Bare</title></release>`,
+	}
+	for _, raw := range samples {
+		assertProto(t, file, decodeProtoSample(t, raw).(protoMessage))
+	}
+
+	data, err := os.ReadFile("testdata/discogs_20250101_releases.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	splitter := NewElementSplitter(strings.NewReader(string(data)))
+	for {
+		raw, err := splitter.Next()
+		if err != nil {
+			break
+		}
+		assertProto(t, file, decodeProtoSample(t, string(raw)).(protoMessage))
+	}
+}
+
+// appendMessage moves messages over 127 bytes to make room for their
+// length, without touching what comes before them.
+func TestAppendMessageLength(t *testing.T) {
+	file := protoFile(t)
+	for _, size := range []int{0, 1, 123, 124, 125, 126, 127, 16383, 1 << 21} {
+		artist := &Artist{}
+		artist.ID = 2
+		artist.Aliases = []*Name{{ID: 1, Name: strings.Repeat("n", size)}, {ID: 3}}
+		assertProto(t, file, artist)
+
+		prefix := []byte{0xff, 0xfe}
+		b := appendMessage(prefix, 7, artist.Aliases[0])
+		if !bytes.Equal(b[:len(prefix)], prefix) {
+			t.Errorf("%d: the bytes before the message were overwritten", size)
+		}
+	}
+}
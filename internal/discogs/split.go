@@ -0,0 +1,183 @@
+package discogs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ElementSplitter splits a dump into the raw XML of its entities, the
+// children of the root element, without decoding them.
+//
+// Splitting only looks for tag boundaries, which is a lot cheaper than
+// tokenizing the whole document, so that decoding can be spread over several
+// goroutines with DecodeRawElement.
+type ElementSplitter struct {
+	r     *bufio.Reader
+	depth int
+	buf   []byte
+}
+
+// NewElementSplitter returns an ElementSplitter reading from r.
+func NewElementSplitter(r io.Reader) *ElementSplitter {
+	return &ElementSplitter{r: bufio.NewReaderSize(r, 1<<20)}
+}
+
+// Next returns the raw XML of the next entity, or io.EOF when there is none
+// left. The returned slice is owned by the caller.
+func (s *ElementSplitter) Next() ([]byte, error) {
+	s.buf = nil
+
+	for {
+		// Character data between entities is dropped, within an entity it is
+		// kept verbatim.
+		text, err := s.readUntil('<')
+		if s.depth >= 2 {
+			s.buf = append(s.buf, text...)
+		}
+		if err == io.EOF {
+			if s.depth > 0 || len(s.buf) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tag, err := s.readTag()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case bytes.HasPrefix(tag, []byte("</")):
+			if s.depth >= 2 {
+				s.buf = append(s.buf, tag...)
+			}
+			s.depth--
+			if s.depth < 0 {
+				return nil, fmt.Errorf("unexpected end element %s", tag)
+			}
+			if s.depth == 1 {
+				return s.buf, nil
+			}
+		case bytes.HasPrefix(tag, []byte("<?")), bytes.HasPrefix(tag, []byte("<!")):
+			// Processing instructions, comments, CDATA sections and
+			// declarations don't change the depth.
+			if s.depth >= 2 {
+				s.buf = append(s.buf, tag...)
+			}
+		case bytes.HasSuffix(tag, []byte("/>")):
+			if s.depth == 1 {
+				return append(s.buf, tag...), nil
+			}
+			if s.depth >= 2 {
+				s.buf = append(s.buf, tag...)
+			}
+		default:
+			if s.depth >= 1 {
+				s.buf = append(s.buf, tag...)
+			}
+			s.depth++
+		}
+	}
+}
+
+// readUntil returns the bytes up to delim, excluding it. delim is consumed.
+func (s *ElementSplitter) readUntil(delim byte) ([]byte, error) {
+	var text []byte
+	for {
+		chunk, err := s.r.ReadSlice(delim)
+		if err == bufio.ErrBufferFull {
+			text = append(text, chunk...)
+			continue
+		}
+		if err != nil {
+			return append(text, chunk...), err
+		}
+		if text == nil {
+			return chunk[:len(chunk)-1], nil
+		}
+		return append(text, chunk[:len(chunk)-1]...), nil
+	}
+}
+
+// readTag returns a whole tag, from the already consumed '<' to its closing
+// '>'. Quoted attribute values may contain '>'.
+func (s *ElementSplitter) readTag() ([]byte, error) {
+	tag := []byte{'<'}
+	var quote byte
+
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return nil, err
+		}
+		tag = append(tag, c)
+
+		switch {
+		case len(tag) == 2 && c == '!':
+			return s.readDeclaration(tag)
+		case len(tag) == 2 && c == '?':
+			return s.readProcessingInstruction(tag)
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return tag, nil
+		}
+	}
+}
+
+// readProcessingInstruction reads a processing instruction up to "?>". Its
+// content isn't made of attributes, quotes and '>' don't end it.
+func (s *ElementSplitter) readProcessingInstruction(tag []byte) ([]byte, error) {
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return nil, err
+		}
+		tag = append(tag, c)
+
+		if c == '>' && len(tag) > 3 && tag[len(tag)-2] == '?' {
+			return tag, nil
+		}
+	}
+}
+
+// readDeclaration reads comments, CDATA sections and other declarations
+// starting with "<!", which may contain '>'.
+func (s *ElementSplitter) readDeclaration(tag []byte) ([]byte, error) {
+	terminator := []byte(">")
+
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return nil, err
+		}
+		tag = append(tag, c)
+
+		switch {
+		case len(tag) == len("<!--") && bytes.Equal(tag, []byte("<!--")):
+			terminator = []byte("-->")
+		case len(tag) == len("<![CDATA[") && bytes.Equal(tag, []byte("<![CDATA[")):
+			terminator = []byte("]]>")
+		case c == '>' && bytes.HasSuffix(tag, terminator) && len(tag) > 4:
+			return tag, nil
+		}
+	}
+}
+
+func (s *ElementSplitter) readByte() (byte, error) {
+	c, err := s.r.ReadByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return c, err
+}
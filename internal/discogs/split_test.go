@@ -0,0 +1,205 @@
+package discogs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// splitReaders wrap the input of the splitter so that tags and entities are
+// split across reads.
+var splitReaders = map[string]func(r io.Reader) io.Reader{
+	"whole":    func(r io.Reader) io.Reader { return r },
+	"one byte": iotest.OneByteReader,
+	"half":     iotest.HalfReader,
+}
+
+func splitAll(t *testing.T, r io.Reader) ([]string, error) {
+	t.Helper()
+
+	s := NewElementSplitter(r)
+	var elements []string
+	for {
+		raw, err := s.Next()
+		if err == io.EOF {
+			return elements, nil
+		}
+		if err != nil {
+			return elements, err
+		}
+		elements = append(elements, string(raw))
+	}
+}
+
+func TestElementSplitter(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "declaration and whitespace",
+			input: "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<artists>\n  <artist><id>1</id></artist>\n  <artist><id>2</id></artist>\n</artists>\n",
+			want:  []string{"<artist><id>1</id></artist>", "<artist><id>2</id></artist>"},
+		},
+		{
+			name:  "nested tags of the same name",
+			input: `<releases><release id="1"><tracklist><track><title>A</title><sub_tracks><track><title>A1</title></track><track/></sub_tracks></track></tracklist></release><release id="2"><release>inner</release></release></releases>`,
+			want: []string{
+				`<release id="1"><tracklist><track><title>A</title><sub_tracks><track><title>A1</title></track><track/></sub_tracks></track></tracklist></release>`,
+				`<release id="2"><release>inner</release></release>`,
+			},
+		},
+		{
+			name:  "self-closing entities",
+			input: `<labels><label id="1"/><label><name>Two</name></label><label id="3" /></labels>`,
+			want:  []string{`<label id="1"/>`, `<label><name>Two</name></label>`, `<label id="3" />`},
+		},
+		{
+			name:  "CDATA sections and comments",
+			input: `<masters><!-- <master> --><master><notes><![CDATA[</master> <b> ]]></notes><!-- </master> --><?pi </master>?></master><!----></masters>`,
+			want:  []string{`<master><notes><![CDATA[</master> <b> ]]></notes><!-- </master> --><?pi </master>?></master>`},
+		},
+		{
+			name:  "'>' in processing instructions",
+			input: `<?xml-stylesheet href="a>b"?><?pi it's > b?><masters><master><?pi a > </master> ?><id>1</id></master><?pi >?></masters>`,
+			want:  []string{`<master><?pi a > </master> ?><id>1</id></master>`},
+		},
+		{
+			name:  "'>' in comments",
+			input: `<!-- a > b --><masters><!-- a > <master> --><master><!-- a > </master> --><id>1</id></master><!-- -> > --></masters>`,
+			want:  []string{`<master><!-- a > </master> --><id>1</id></master>`},
+		},
+		{
+			name:  "quoted attributes",
+			input: `<labels><label name="a > b" alt='</label>' title="it's"><id>1</id></label></labels>`,
+			want:  []string{`<label name="a > b" alt='</label>' title="it's"><id>1</id></label>`},
+		},
+		{
+			name:  "character data",
+			input: "<artists><artist>\n\t<name>A &amp; B</name>\n</artist></artists>",
+			want:  []string{"<artist>\n\t<name>A &amp; B</name>\n</artist>"},
+		},
+		{
+			name:  "empty document",
+			input: "<artists></artists>",
+		},
+	}
+
+	for _, tt := range tests {
+		for name, wrap := range splitReaders {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				got, err := splitAll(t, wrap(strings.NewReader(tt.input)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestElementSplitterTruncated(t *testing.T) {
+	inputs := []string{
+		"<artists><artist><id>1</id>",
+		"<artists><artist><id>1</id></artist>",
+		`<artists><artist name="unterminated`,
+		"<artists><artist><!-- unterminated",
+		"<artists><artist><![CDATA[ unterminated ]]",
+		"<artists><artist><?pi unterminated >",
+		"<artists><artist><!-- unterminated >",
+	}
+
+	for _, input := range inputs {
+		for name, wrap := range splitReaders {
+			t.Run(name, func(t *testing.T) {
+				_, err := splitAll(t, wrap(strings.NewReader(input)))
+				if !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Errorf("%q: got %v, want %v", input, err, io.ErrUnexpectedEOF)
+				}
+			})
+		}
+	}
+}
+
+func TestElementSplitterUnexpectedEndElement(t *testing.T) {
+	_, err := splitAll(t, strings.NewReader("<artists></artists></artists>"))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// Entities larger than the buffer of the splitter are read in several
+// chunks.
+func TestElementSplitterLargeEntity(t *testing.T) {
+	profile := strings.Repeat("Lorem ipsum dolor sit amet. ", 3<<20/28)
+	entity := "<artist><profile>" + profile + "</profile></artist>"
+
+	got, err := splitAll(t, iotest.HalfReader(strings.NewReader("<artists>"+entity+"<artist/></artists>")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != entity || got[1] != "<artist/>" {
+		t.Errorf("got %d entities, want the large entity and <artist/>", len(got))
+	}
+}
+
+// Decoding the entities split from a dump yields the elements
+// DecodeNextElement decodes, in the same order.
+func TestElementSplitterMatchesDecoder(t *testing.T) {
+	data, err := os.ReadFile("testdata/discogs_20250101_releases.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dump, err := NewDump(bytes.NewReader(data), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []any
+	for {
+		element, err := dump.DecodeNextElement()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if element != nil {
+			want = append(want, element)
+		}
+	}
+
+	for name, wrap := range splitReaders {
+		t.Run(name, func(t *testing.T) {
+			raws, err := splitAll(t, wrap(bytes.NewReader(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []any
+			for _, raw := range raws {
+				element, err := DecodeRawElement([]byte(raw), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, element)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got %d elements, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if !reflect.DeepEqual(got[i], want[i]) {
+					t.Fatalf("element %d: got %+v, want %+v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"os"
+	"time"
 
 	"github.com/urfave/cli/v3"
 )
@@ -17,9 +18,52 @@ func main() {
 		Version: VERSION,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:  "discogs-bucket",
-				Usage: "The URL of the Discogs data dumps",
-				Value: "https://discogs-data-dumps.s3.us-west-2.amazonaws.com",
+				Name:    "discogs-bucket",
+				Usage:   "The URL of the Discogs data dumps",
+				Value:   "https://discogs-data-dumps.s3.us-west-2.amazonaws.com",
+				Sources: cli.EnvVars("DGTOOLS_BUCKET"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "bucket-mirror",
+				Usage:   "The URL of a mirror of the bucket, tried in order when the bucket fails",
+				Sources: cli.EnvVars("DGTOOLS_BUCKET_MIRRORS"),
+			},
+			&cli.DurationFlag{
+				Name:    "http-timeout",
+				Usage:   "How long to wait for a connection, a response or more data from the bucket, 0 to wait forever",
+				Value:   30 * time.Second,
+				Sources: cli.EnvVars("DGTOOLS_HTTP_TIMEOUT"),
+			},
+			&cli.IntFlag{
+				Name:    "http-retries",
+				Usage:   "The number of times a request failing with a network error, a 429 or a 5xx is retried",
+				Value:   3,
+				Sources: cli.EnvVars("DGTOOLS_HTTP_RETRIES"),
+			},
+			&cli.StringFlag{
+				Name:    "http-proxy",
+				Usage:   "The URL of the proxy to the bucket, HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used otherwise",
+				Sources: cli.EnvVars("DGTOOLS_HTTP_PROXY"),
+			},
+			&cli.StringFlag{
+				Name:    "ca-cert",
+				Usage:   "A PEM bundle of certificate authorities trusted along with the system ones",
+				Sources: cli.EnvVars("DGTOOLS_CA_CERT"),
+			},
+			&cli.StringFlag{
+				Name:    "user-agent",
+				Usage:   "The User-Agent of the requests to the bucket",
+				Value:   "dgtools/" + VERSION,
+				Sources: cli.EnvVars("DGTOOLS_USER_AGENT"),
+			},
+			&cli.DurationFlag{
+				Name:  "listing-cache-ttl",
+				Usage: "How long the listing of the bucket is cached between commands, 0 to disable the cache",
+				Value: time.Hour,
+			},
+			&cli.BoolFlag{
+				Name:  "no-catalog",
+				Usage: "Neither record the dumps downloaded, verified, converted and imported in the catalog of their directory, nor use it to skip work",
 			},
 		},
 		Commands: []*cli.Command{
@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE discogs_releases ADD COLUMN IF NOT EXISTS released_date date;
+ALTER TABLE discogs_releases ADD COLUMN IF NOT EXISTS released_precision character varying;
+-- +goose Down
+ALTER TABLE discogs_releases DROP COLUMN released_date;
+ALTER TABLE discogs_releases DROP COLUMN released_precision;
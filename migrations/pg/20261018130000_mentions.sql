@@ -0,0 +1,34 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS discogs_artist_mentions (
+    artist_id integer NOT NULL,
+    mentioned_type character varying NOT NULL,
+    mentioned_id integer NOT NULL
+);
+CREATE TABLE IF NOT EXISTS discogs_label_mentions (
+    label_id integer NOT NULL,
+    mentioned_type character varying NOT NULL,
+    mentioned_id integer NOT NULL
+);
+CREATE TABLE IF NOT EXISTS discogs_master_mentions (
+    master_id integer NOT NULL,
+    mentioned_type character varying NOT NULL,
+    mentioned_id integer NOT NULL
+);
+CREATE TABLE IF NOT EXISTS discogs_release_mentions (
+    release_id integer NOT NULL,
+    mentioned_type character varying NOT NULL,
+    mentioned_id integer NOT NULL
+);
+CREATE INDEX IF NOT EXISTS index_discogs_artist_mentions_on_artist_id ON discogs_artist_mentions USING btree (artist_id);
+CREATE INDEX IF NOT EXISTS index_discogs_artist_mentions_on_mentioned_type_and_mentioned_id ON discogs_artist_mentions USING btree (mentioned_type, mentioned_id);
+CREATE INDEX IF NOT EXISTS index_discogs_label_mentions_on_label_id ON discogs_label_mentions USING btree (label_id);
+CREATE INDEX IF NOT EXISTS index_discogs_label_mentions_on_mentioned_type_and_mentioned_id ON discogs_label_mentions USING btree (mentioned_type, mentioned_id);
+CREATE INDEX IF NOT EXISTS index_discogs_master_mentions_on_master_id ON discogs_master_mentions USING btree (master_id);
+CREATE INDEX IF NOT EXISTS index_discogs_master_mentions_on_mentioned_type_and_mentioned_id ON discogs_master_mentions USING btree (mentioned_type, mentioned_id);
+CREATE INDEX IF NOT EXISTS index_discogs_release_mentions_on_release_id ON discogs_release_mentions USING btree (release_id);
+CREATE INDEX IF NOT EXISTS index_discogs_release_mentions_on_mentioned_type_and_mentioned_id ON discogs_release_mentions USING btree (mentioned_type, mentioned_id);
+-- +goose Down
+DROP TABLE discogs_artist_mentions;
+DROP TABLE discogs_label_mentions;
+DROP TABLE discogs_master_mentions;
+DROP TABLE discogs_release_mentions;
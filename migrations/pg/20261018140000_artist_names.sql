@@ -0,0 +1,20 @@
+-- +goose Up
+ALTER TABLE discogs_artists ADD COLUMN IF NOT EXISTS display_name character varying;
+ALTER TABLE discogs_artists ADD COLUMN IF NOT EXISTS disambiguation integer;
+ALTER TABLE discogs_artists ADD COLUMN IF NOT EXISTS sort_name character varying;
+ALTER TABLE discogs_master_artists ADD COLUMN IF NOT EXISTS display_name character varying;
+ALTER TABLE discogs_master_artists ADD COLUMN IF NOT EXISTS disambiguation integer;
+ALTER TABLE discogs_master_artists ADD COLUMN IF NOT EXISTS sort_name character varying;
+ALTER TABLE discogs_release_artists ADD COLUMN IF NOT EXISTS display_name character varying;
+ALTER TABLE discogs_release_artists ADD COLUMN IF NOT EXISTS disambiguation integer;
+ALTER TABLE discogs_release_artists ADD COLUMN IF NOT EXISTS sort_name character varying;
+ALTER TABLE discogs_release_extra_artists ADD COLUMN IF NOT EXISTS display_name character varying;
+ALTER TABLE discogs_release_extra_artists ADD COLUMN IF NOT EXISTS disambiguation integer;
+ALTER TABLE discogs_release_extra_artists ADD COLUMN IF NOT EXISTS sort_name character varying;
+CREATE INDEX IF NOT EXISTS index_discogs_artists_on_sort_name ON discogs_artists USING btree (sort_name);
+-- +goose Down
+DROP INDEX IF EXISTS index_discogs_artists_on_sort_name;
+ALTER TABLE discogs_artists DROP COLUMN display_name, DROP COLUMN disambiguation, DROP COLUMN sort_name;
+ALTER TABLE discogs_master_artists DROP COLUMN display_name, DROP COLUMN disambiguation, DROP COLUMN sort_name;
+ALTER TABLE discogs_release_artists DROP COLUMN display_name, DROP COLUMN disambiguation, DROP COLUMN sort_name;
+ALTER TABLE discogs_release_extra_artists DROP COLUMN display_name, DROP COLUMN disambiguation, DROP COLUMN sort_name;